@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"context"
+	"time"
+
 	"github.com/Brickchain/go-document.v2"
 	keys "github.com/Brickchain/go-keys.v1"
 	jose "gopkg.in/square/go-jose.v1"
@@ -12,18 +15,38 @@ type Binding interface {
 	// ID returns the ID of the binding.
 	ID() string
 
-	// Secret for the binding.
+	// Secret for the binding. Backends only store a salted hash of the secret, so it is only
+	// available on the Binding returned by BindingService.New and is empty once loaded again.
 	Secret() string
 
-	// GenerateKey will generate a new keypair for this binding.
+	// VerifySecret reports if the secret matches the binding secret, in constant time.
+	// The secret replaced by RotateSecret is accepted until its grace period has passed.
+	VerifySecret(string) bool
+
+	// RotateSecret replaces the binding secret and returns the new one. The previous secret
+	// is still accepted by VerifySecret for the grace period, any older secret is dropped.
+	RotateSecret(time.Duration) (string, error)
+
+	// GenerateKey will generate a new keypair for this binding. An existing key is retired and
+	// its private key is kept in the StoredKeyService under RetiredKeyID.
 	GenerateKey(keys.StoredKeyService, []byte) error
 
+	// GenerateKeyWithOptions is like GenerateKey but generates the kind of key selected by the KeyOptions.
+	GenerateKeyWithOptions(keys.StoredKeyService, []byte, KeyOptions) error
+
 	// PublicKey of the binding.
 	PublicKey() *jose.JsonWebKey
 
+	// PublicKeys returns the current key followed by the retired keys, newest first.
+	PublicKeys() []BindingKey
+
 	// PrivateKey of the binding. Requires a StoredKeyService and a Key Encryption Key (KEK).
+	// Prefer Signer, which does not hand out the key material.
 	PrivateKey(keys.StoredKeyService, []byte) (*jose.JsonWebKey, error)
 
+	// Signer returns a Signer for the private key operations of the binding from the KeyStore.
+	Signer(KeyStore) (Signer, error)
+
 	// Descriptor returns the ControllerDescriptor for this binding
 	Descriptor() document.ControllerDescriptor
 
@@ -33,7 +56,7 @@ type Binding interface {
 	// Bind is used when the realm binds to this binding.
 	Bind(*document.ControllerBinding) error
 
-	// Unbind removes the realm binding
+	// Unbind removes the realm binding. Returns ErrNotBound if the Binding is not bound.
 	Unbind() error
 
 	// Certificate of the binding.
@@ -63,3 +86,33 @@ type Binding interface {
 	// SetBindEndpoint updates the BindEndpoint value.
 	SetBindEndpoint(string) error
 }
+
+// ContextBinding is a Binding where the operations that write to the backend also accept a context.Context.
+// The context is used for cancellation and deadlines of the underlying storage calls.
+type ContextBinding interface {
+	Binding
+
+	// GenerateKeyContext is like GenerateKey but with a context.
+	GenerateKeyContext(context.Context, keys.StoredKeyService, []byte) error
+
+	// GenerateKeyWithOptionsContext is like GenerateKeyWithOptions but with a context.
+	GenerateKeyWithOptionsContext(context.Context, keys.StoredKeyService, []byte, KeyOptions) error
+
+	// SetDescriptorContext is like SetDescriptor but with a context.
+	SetDescriptorContext(context.Context, document.ControllerDescriptor) error
+
+	// BindContext is like Bind but with a context.
+	BindContext(context.Context, *document.ControllerBinding) error
+
+	// UnbindContext is like Unbind but with a context.
+	UnbindContext(context.Context) error
+
+	// SetStatusContext is like SetStatus but with a context.
+	SetStatusContext(context.Context, string) error
+
+	// SetBindEndpointContext is like SetBindEndpoint but with a context.
+	SetBindEndpointContext(context.Context, string) error
+
+	// RotateSecretContext is like RotateSecret but with a context.
+	RotateSecretContext(context.Context, time.Duration) (string, error)
+}
@@ -66,6 +66,59 @@ func Test_Binding_Secret(t *testing.T) {
 	}
 }
 
+func Test_Binding_VerifySecret(t *testing.T) {
+	type test struct {
+		name   string
+		secret func(string) string
+		want   bool
+	}
+	tests := []test{
+		{
+			name:   "Correct",
+			secret: func(s string) string { return s },
+			want:   true,
+		},
+		{
+			name:   "Wrong",
+			secret: func(s string) string { return s + "x" },
+			want:   false,
+		},
+		{
+			name:   "Empty",
+			secret: func(s string) string { return "" },
+			want:   false,
+		},
+	}
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					bsvc := svc.Create(t)
+					g, err := bsvc.New("test")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := g.VerifySecret(tt.secret(g.Secret())); got != tt.want {
+						t.Errorf("Binding.VerifySecret() = %v, want %v", got, tt.want)
+					}
+
+					b, err := bsvc.Get("test")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := b.VerifySecret(tt.secret(g.Secret())); got != tt.want {
+						t.Errorf("Binding.VerifySecret() after Get = %v, want %v", got, tt.want)
+					}
+					// the mock hands out the Binding from New again
+					if b != g && b.Secret() != "" {
+						t.Errorf("Binding.Secret() after Get = %v, want empty value", b.Secret())
+					}
+				})
+			}
+		})
+	}
+}
+
 func Test_Binding_GenerateKey(t *testing.T) {
 	type test struct {
 		name    string
@@ -251,6 +304,27 @@ func Test_Binding_Bind(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Commas",
+			prepare: func(t *testing.T, tt *test) {
+				tt.binding, _ = tt.svc.New("test")
+				if err := tt.binding.Bind(&document.ControllerBinding{
+					AdminRoles: []string{"admin,ops@example.com", "user"},
+					Mandates:   []string{`{"payload":"abc","signatures":[{"protected":"def","signature":"ghi"}]}`, "jkl"},
+				}); err != nil {
+					t.Fatal(err)
+				}
+				tt.binding, _ = tt.svc.Get("test")
+			},
+			verify: func(t *testing.T, tt *test) {
+				if roles := tt.binding.AdminRoles(); len(roles) != 2 || roles[0] != "admin,ops@example.com" {
+					t.Fatalf("AdminRoles not correct: %v", roles)
+				}
+				if mandates := tt.binding.Mandates(); len(mandates) != 2 || mandates[1] != "jkl" {
+					t.Fatalf("Mandates not correct: %v", mandates)
+				}
+			},
+		},
 		{
 			name: "PostBind",
 			prepare: func(t *testing.T, tt *test) {
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/Brickchain/go-crypto.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// DefaultKeyRetention is how long a retired binding key is kept and published by default.
+const DefaultKeyRetention = 7 * 24 * time.Hour
+
+// StoredKeyDeleter is implemented by a StoredKeyService that can delete keys.
+// A StoredKeyService without it has the private keys that are removed overwritten with an empty StoredKey.
+type StoredKeyDeleter interface {
+	Delete(id string) error
+}
+
+// deleteStoredKey removes the private key with the ID from the StoredKeyService
+func deleteStoredKey(svc keys.StoredKeyService, id string) error {
+	if d, ok := svc.(StoredKeyDeleter); ok {
+		return d.Delete(id)
+	}
+
+	return svc.Save(&keys.StoredKey{ID: id})
+}
+
+// BindingKey is a public key of a binding with the time it was generated.
+// Keys replaced by GenerateKey are kept with the time they were retired, so realms that
+// cached an old key can still verify what was signed with it.
+type BindingKey struct {
+	Key     *jose.JsonWebKey `json:"key"`
+	Created time.Time        `json:"created"`
+	Retired time.Time        `json:"retired,omitempty"`
+}
+
+// Active reports if this is the current key of the binding.
+func (k BindingKey) Active() bool {
+	return k.Retired.IsZero()
+}
+
+// ValidAt reports if the key is active, or was retired less than retention before t.
+func (k BindingKey) ValidAt(t time.Time, retention time.Duration) bool {
+	return k.Active() || t.Before(k.Retired.Add(retention))
+}
+
+// RetiredKeyID returns the ID the private key of a retired binding key is stored under in the StoredKeyService.
+func RetiredKeyID(id string, key *jose.JsonWebKey) string {
+	return id + "/" + crypto.Thumbprint(key)
+}
+
+// RetireKey keeps the current key of a binding before GenerateKey replaces it. The stored private key
+// is copied to RetiredKeyID and the returned history has the current key, retired at t, first.
+func RetireKey(svc keys.StoredKeyService, id string, current BindingKey, retired []BindingKey, t time.Time) ([]BindingKey, error) {
+	if current.Key == nil {
+		return retired, nil
+	}
+
+	skey, err := svc.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	old := *skey
+	old.ID = RetiredKeyID(id, current.Key)
+	if err = svc.Save(&old); err != nil {
+		return nil, err
+	}
+
+	current.Retired = t
+
+	return append([]BindingKey{current}, retired...), nil
+}
+
+// KeySet returns the current key followed by the retired keys, newest first.
+func KeySet(current BindingKey, retired []BindingKey) []BindingKey {
+	set := make([]BindingKey, 0, len(retired)+1)
+	if current.Key != nil {
+		set = append(set, current)
+	}
+
+	return append(set, retired...)
+}
+
+// ReplaceKey generates a new key for the binding with the ID and makes it the current key, keeping the
+// StoredKeyService and the binding record consistent when either of them fails. The current key is
+// retired, the new private key is stored under the ID and save is called to persist the new key and
+// history in the binding record. If storing the new key or save fails, the previous private key is stored
+// again, or the new one is deleted when the binding had no key, and the retired copy of the current key is deleted.
+// Retired keys past the retention of the KeyOptions are left out of the history and their private
+// keys are deleted once the binding record is saved.
+func ReplaceKey(svc keys.StoredKeyService, id string, kek []byte, opts KeyOptions, current BindingKey, retired []BindingKey, save func(BindingKey, []BindingKey) error) error {
+	now := time.Now()
+
+	key, pk, err := NewKeyPair(opts)
+	if err != nil {
+		return err
+	}
+
+	skey := &keys.StoredKey{
+		ID: id,
+	}
+
+	if err = skey.Encrypt(key, kek); err != nil {
+		return err
+	}
+
+	var previous *keys.StoredKey
+	if current.Key != nil {
+		if previous, err = svc.Get(id); err != nil {
+			return err
+		}
+	}
+
+	history, err := RetireKey(svc, id, current, retired, now)
+	if err != nil {
+		return err
+	}
+
+	history, expired := pruneKeys(history, now, opts.retention())
+
+	// rollback deletes the retired copy of the current key, restores the previous private key
+	// or deletes the new one when the binding had no key
+	rollback := func(err error) error {
+		if previous == nil {
+			if rerr := deleteStoredKey(svc, id); rerr != nil {
+				return errors.Wrapf(err, "failed to delete the new key (%s)", rerr)
+			}
+			return err
+		}
+
+		if rerr := svc.Save(previous); rerr != nil {
+			return errors.Wrapf(err, "failed to restore the previous key (%s)", rerr)
+		}
+		if rerr := deleteStoredKey(svc, RetiredKeyID(id, current.Key)); rerr != nil {
+			return errors.Wrapf(err, "failed to delete the retired key (%s)", rerr)
+		}
+		return err
+	}
+
+	if err = svc.Save(skey); err != nil {
+		return rollback(err)
+	}
+
+	if err = save(BindingKey{Key: pk, Created: now}, history); err != nil {
+		return rollback(err)
+	}
+
+	// the record no longer refers to the expired keys, a key that fails to be deleted is only left unused
+	for _, k := range expired {
+		deleteStoredKey(svc, RetiredKeyID(id, k.Key))
+	}
+
+	return nil
+}
+
+// pruneKeys splits the retired keys into the ones still valid at t and the ones past retention
+func pruneKeys(retired []BindingKey, t time.Time, retention time.Duration) ([]BindingKey, []BindingKey) {
+	kept := make([]BindingKey, 0, len(retired))
+	expired := make([]BindingKey, 0)
+	for _, k := range retired {
+		if k.ValidAt(t, retention) {
+			kept = append(kept, k)
+		} else {
+			expired = append(expired, k)
+		}
+	}
+
+	return kept, expired
+}
+
+// CheckKey verifies that the private key of the binding in the StoredKeyService matches its public key.
+// A binding without a public key must not have a private key stored, else ErrOrphanKey is returned.
+// Returns ErrKeyMismatch if it does not, or the error from the StoredKeyService if the key is missing.
+func CheckKey(b Binding, svc keys.StoredKeyService, kek []byte) error {
+	pk := b.PublicKey()
+	if pk == nil {
+		if skey, err := svc.Get(b.ID()); err == nil && skey.Key != "" {
+			return ErrOrphanKey
+		}
+		return nil
+	}
+
+	key, err := b.PrivateKey(svc, kek)
+	if err != nil {
+		return err
+	}
+
+	stored, err := crypto.NewPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	if crypto.Thumbprint(stored) != crypto.Thumbprint(pk) {
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+// KeyInconsistency is a binding found by CheckKeys, with the error from CheckKey.
+type KeyInconsistency struct {
+	ID  string
+	Err error
+}
+
+// CheckKeys runs CheckKey for every binding in the BindingService and returns the bindings that failed.
+func CheckKeys(ctx context.Context, bsvc BindingService, svc keys.StoredKeyService, kek []byte) ([]KeyInconsistency, error) {
+	found := make([]KeyInconsistency, 0)
+
+	err := walkBindings(ctx, bsvc, ListOptions{Limit: 100}, func(b Binding) error {
+		if err := CheckKey(b, svc, kek); err != nil {
+			found = append(found, KeyInconsistency{ID: b.ID(), Err: err})
+		}
+		return nil
+	})
+
+	return found, err
+}
@@ -0,0 +1,204 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// cancellingKeyService cancels the context once the key with the ID has been saved,
+// so the binding record update that follows fails
+type cancellingKeyService struct {
+	keys.StoredKeyService
+	id     string
+	cancel context.CancelFunc
+}
+
+func (c *cancellingKeyService) Save(k *keys.StoredKey) error {
+	err := c.StoredKeyService.Save(k)
+	if k.ID == c.id {
+		c.cancel()
+	}
+
+	return err
+}
+
+func Test_Binding_GenerateKey_Atomic(t *testing.T) {
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			bsvc := svc.Create(t)
+			ksvc := keys.NewMockStoredKeyService()
+			kek := crypto.NewSymmetricKey(jose.A256KW)
+
+			b, err := bsvc.New("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := b.GenerateKey(ksvc, kek); err != nil {
+				t.Fatal(err)
+			}
+			b, _ = bsvc.Get("test")
+			want := crypto.Thumbprint(b.PublicKey())
+
+			tests := []struct {
+				name     string
+				generate func(controller.Binding) error
+			}{
+				{
+					name: "KeyStoreFails",
+					generate: func(b controller.Binding) error {
+						return b.GenerateKey(&failingKeyService{StoredKeyService: ksvc, failAt: 2}, kek)
+					},
+				},
+				{
+					name: "RecordFails",
+					generate: func(b controller.Binding) error {
+						ctx, cancel := context.WithCancel(context.Background())
+						defer cancel()
+						cks := &cancellingKeyService{StoredKeyService: ksvc, id: "test", cancel: cancel}
+						return b.(controller.ContextBinding).GenerateKeyContext(ctx, cks, kek)
+					},
+				},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					if tt.name == "RecordFails" && (svc.Name == "Mock" || svc.Name == "Memory") {
+						t.Skip("the in-memory services do not fail record writes")
+					}
+					b, _ := bsvc.Get("test")
+					if err := tt.generate(b); err == nil {
+						t.Fatal("Binding.GenerateKey() should fail")
+					}
+					if got := crypto.Thumbprint(b.PublicKey()); got != want {
+						t.Errorf("Binding.PublicKey() changed after failed GenerateKey")
+					}
+
+					b, _ = bsvc.Get("test")
+					if got := crypto.Thumbprint(b.PublicKey()); got != want {
+						t.Errorf("stored public key changed after failed GenerateKey")
+					}
+					if err := controller.CheckKey(b, ksvc, kek); err != nil {
+						t.Errorf("CheckKey() after failed GenerateKey error = %v", err)
+					}
+					if tt.name == "RecordFails" {
+						if skey, err := ksvc.Get(controller.RetiredKeyID("test", b.PublicKey())); err == nil && skey.Key != "" {
+							t.Error("retired copy of the key kept after failed GenerateKey")
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_ReplaceKey_Rollback(t *testing.T) {
+	kek := crypto.NewSymmetricKey(jose.A256KW)
+	failSave := func(controller.BindingKey, []controller.BindingKey) error {
+		return errors.New("record unavailable")
+	}
+
+	t.Run("FirstKey", func(t *testing.T) {
+		ksvc := keys.NewMockStoredKeyService()
+
+		if err := controller.ReplaceKey(ksvc, "test", kek, controller.KeyOptions{}, controller.BindingKey{}, nil, failSave); err == nil {
+			t.Fatal("ReplaceKey() should fail")
+		}
+		if skey, err := ksvc.Get("test"); err == nil && skey.Key != "" {
+			t.Error("ReplaceKey() left the new private key stored")
+		}
+
+		b, err := controller.NewMemoryBindingService().New("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := controller.CheckKey(b, ksvc, kek); err != nil {
+			t.Errorf("CheckKey() after failed ReplaceKey error = %v", err)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		ksvc := keys.NewMockStoredKeyService()
+
+		var current controller.BindingKey
+		err := controller.ReplaceKey(ksvc, "test", kek, controller.KeyOptions{}, current, nil, func(k controller.BindingKey, _ []controller.BindingKey) error {
+			current = k
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous, _ := ksvc.Get("test")
+
+		if err := controller.ReplaceKey(ksvc, "test", kek, controller.KeyOptions{}, current, nil, failSave); err == nil {
+			t.Fatal("ReplaceKey() should fail")
+		}
+		if skey, err := ksvc.Get("test"); err != nil || skey.Key != previous.Key {
+			t.Error("ReplaceKey() did not restore the previous private key")
+		}
+		if skey, err := ksvc.Get(controller.RetiredKeyID("test", current.Key)); err == nil && skey.Key != "" {
+			t.Error("ReplaceKey() left the retired copy of the key stored")
+		}
+	})
+}
+
+func Test_CheckKeys(t *testing.T) {
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			bsvc := svc.Create(t)
+			ksvc := keys.NewMockStoredKeyService()
+			kek := crypto.NewSymmetricKey(jose.A256KW)
+
+			for _, id := range []string{"missing", "mismatch", "ok", "orphan", "unkeyed"} {
+				b, err := bsvc.New(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				switch id {
+				case "missing":
+					err = b.GenerateKey(keys.NewMockStoredKeyService(), kek)
+				case "mismatch":
+					err = b.GenerateKey(ksvc, kek)
+					if err == nil {
+						other, _ := crypto.NewKey()
+						skey := &keys.StoredKey{ID: id}
+						skey.Encrypt(other, kek)
+						err = ksvc.Save(skey)
+					}
+				case "ok":
+					err = b.GenerateKey(ksvc, kek)
+				case "orphan":
+					key, _ := crypto.NewKey()
+					skey := &keys.StoredKey{ID: id}
+					skey.Encrypt(key, kek)
+					err = ksvc.Save(skey)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			found, err := controller.CheckKeys(context.Background(), bsvc, ksvc, kek)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 3 || found[0].ID != "mismatch" || found[1].ID != "missing" || found[2].ID != "orphan" {
+				t.Fatalf("CheckKeys() = %+v, want mismatch, missing and orphan", found)
+			}
+			if found[0].Err != controller.ErrKeyMismatch {
+				t.Errorf("CheckKeys() mismatch error = %v, want %v", found[0].Err, controller.ErrKeyMismatch)
+			}
+			if found[1].Err == nil {
+				t.Error("CheckKeys() missing key has no error")
+			}
+			if found[2].Err != controller.ErrOrphanKey {
+				t.Errorf("CheckKeys() orphan error = %v, want %v", found[2].Err, controller.ErrOrphanKey)
+			}
+		})
+	}
+}
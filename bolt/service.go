@@ -0,0 +1,234 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	bbolt "go.etcd.io/bbolt"
+)
+
+var bindingsBucket = []byte("bindings")
+
+type boltBindingService struct {
+	db             *bbolt.DB
+	postBindFunc   func(controller.Binding)
+	postUnbindFunc func(controller.Binding)
+}
+
+// New returns a BindingService that stores the bindings in a bbolt database
+func New(db *bbolt.DB) (controller.ContextBindingService, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bindingsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltBindingService{
+		db: db,
+	}, nil
+}
+
+func (b *boltBindingService) SetPostBind(f func(controller.Binding)) {
+	b.postBindFunc = f
+}
+
+func (b *boltBindingService) PostBind() func(controller.Binding) {
+	return b.postBindFunc
+}
+
+func (b *boltBindingService) SetPostUnbind(f func(controller.Binding)) {
+	b.postUnbindFunc = f
+}
+
+func (b *boltBindingService) PostUnbind() func(controller.Binding) {
+	return b.postUnbindFunc
+}
+
+func (b *boltBindingService) binding(rec *boltRecord) *boltBinding {
+	return &boltBinding{
+		svc: b,
+		rec: *rec,
+	}
+}
+
+func (b *boltBindingService) New(id string) (controller.Binding, error) {
+	return b.NewContext(context.Background(), id)
+}
+
+func (b *boltBindingService) NewContext(ctx context.Context, id string) (controller.Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &boltRecord{
+		ID:         id,
+		SecretHash: hash,
+	}
+
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bindingsBucket)
+
+		existing, err := getRecord(bucket, id)
+		if err == nil {
+			rec = existing
+			return controller.ErrAlreadyExists
+		}
+		if err != controller.ErrNotFound {
+			return err
+		}
+
+		return putRecord(bucket, rec)
+	})
+	if err == controller.ErrAlreadyExists {
+		return b.binding(rec), err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	binding := b.binding(rec)
+	binding.secret = secret
+
+	return binding, nil
+}
+
+func (b *boltBindingService) Get(id string) (controller.Binding, error) {
+	return b.GetContext(context.Background(), id)
+}
+
+func (b *boltBindingService) GetContext(ctx context.Context, id string) (controller.Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var rec *boltRecord
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		rec, err = getRecord(tx.Bucket(bindingsBucket), id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.binding(rec), nil
+}
+
+func (b *boltBindingService) Delete(id string) error {
+	return b.DeleteContext(context.Background(), id)
+}
+
+func (b *boltBindingService) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bindingsBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return controller.ErrNotFound
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (b *boltBindingService) List(opts controller.ListOptions) ([]controller.Binding, string, error) {
+	return b.ListContext(context.Background(), opts)
+}
+
+func (b *boltBindingService) ListContext(ctx context.Context, opts controller.ListOptions) ([]controller.Binding, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	bindings := make([]controller.Binding, 0)
+	cursor := ""
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bindingsBucket).Cursor()
+
+		k, v := c.First()
+		if opts.Cursor != "" {
+			k, v = c.Seek([]byte(opts.Cursor))
+			if k != nil && bytes.Equal(k, []byte(opts.Cursor)) {
+				k, v = c.Next()
+			}
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			rec := &boltRecord{}
+			if err := json.Unmarshal(v, rec); err != nil {
+				return err
+			}
+
+			binding := b.binding(rec)
+			if !opts.Match(binding) {
+				continue
+			}
+
+			if opts.Limit > 0 && len(bindings) == opts.Limit {
+				cursor = bindings[len(bindings)-1].ID()
+				return nil
+			}
+
+			bindings = append(bindings, binding)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bindings, cursor, nil
+}
+
+func (b *boltBindingService) FindByRealm(thumbprint string) ([]controller.Binding, error) {
+	return b.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (b *boltBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]controller.Binding, error) {
+	bindings, _, err := b.ListContext(ctx, controller.ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (b *boltBindingService) FindByRealmName(name string) ([]controller.Binding, error) {
+	return b.FindByRealmNameContext(context.Background(), name)
+}
+
+func (b *boltBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]controller.Binding, error) {
+	bindings, _, err := b.ListContext(ctx, controller.ListOptions{Realm: name})
+	return bindings, err
+}
+
+func getRecord(bucket *bbolt.Bucket, id string) (*boltRecord, error) {
+	v := bucket.Get([]byte(id))
+	if v == nil {
+		return nil, controller.ErrNotFound
+	}
+
+	rec := &boltRecord{}
+	if err := json.Unmarshal(v, rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func putRecord(bucket *bbolt.Bucket, rec *boltRecord) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(rec.ID), v)
+}
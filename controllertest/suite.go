@@ -0,0 +1,572 @@
+// Package controllertest contains a conformance test suite for implementations of controller.BindingService.
+package controllertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// Factory returns a new, empty BindingService for each test.
+type Factory func(*testing.T) controller.BindingService
+
+// SuiteOptions configures RunBindingServiceSuiteWithOptions.
+type SuiteOptions struct {
+	// SkipConcurrency skips the concurrency tests, for BindingServices that are not safe for concurrent use.
+	SkipConcurrency bool
+}
+
+// RunBindingServiceSuite runs the conformance tests against the BindingService returned by factory.
+func RunBindingServiceSuite(t *testing.T, factory Factory) {
+	RunBindingServiceSuiteWithOptions(t, factory, SuiteOptions{})
+}
+
+// RunBindingServiceSuiteWithOptions is like RunBindingServiceSuite but with options.
+func RunBindingServiceSuiteWithOptions(t *testing.T, factory Factory, opts SuiteOptions) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, controller.BindingService)
+	}{
+		{"New", testNew},
+		{"Get", testGet},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"Bind", testBind},
+		{"Unbind", testUnbind},
+		{"GenerateKey", testGenerateKey},
+		{"KeyRotation", testKeyRotation},
+		{"KeyOptions", testKeyOptions},
+		{"PostBind", testPostBind},
+		{"PostUnbind", testPostUnbind},
+		{"Descriptor", testDescriptor},
+		{"Status", testStatus},
+		{"BindEndpoint", testBindEndpoint},
+		{"RotateSecret", testRotateSecret},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "Concurrency" && opts.SkipConcurrency {
+				t.Skip("the BindingService is not safe for concurrent use")
+			}
+			tt.run(t, factory(t))
+		})
+	}
+}
+
+func mustNew(t *testing.T, svc controller.BindingService, id string) controller.Binding {
+	t.Helper()
+	b, err := svc.New(id)
+	if err != nil {
+		t.Fatalf("BindingService.New(%q) error = %v", id, err)
+	}
+	return b
+}
+
+func mustGet(t *testing.T, svc controller.BindingService, id string) controller.Binding {
+	t.Helper()
+	b, err := svc.Get(id)
+	if err != nil {
+		t.Fatalf("BindingService.Get(%q) error = %v", id, err)
+	}
+	return b
+}
+
+func testNew(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if b.ID() != "test" {
+		t.Errorf("Binding.ID() = %v, want test", b.ID())
+	}
+	if b.Secret() == "" {
+		t.Error("Binding.Secret() is empty")
+	}
+	if b.ControllerBinding() != nil || b.Realm() != nil {
+		t.Error("New Binding should not be bound")
+	}
+
+	if _, err := svc.New("test"); !errors.Is(err, controller.ErrAlreadyExists) {
+		t.Errorf("BindingService.New() on existing ID error = %v, want %v", err, controller.ErrAlreadyExists)
+	}
+}
+
+func testGet(t *testing.T, svc controller.BindingService) {
+	if _, err := svc.Get("test"); !errors.Is(err, controller.ErrNotFound) {
+		t.Errorf("BindingService.Get() on missing ID error = %v, want %v", err, controller.ErrNotFound)
+	}
+
+	secret := mustNew(t, svc, "test").Secret()
+	b := mustGet(t, svc, "test")
+	if b.ID() != "test" {
+		t.Errorf("Binding.ID() = %v, want test", b.ID())
+	}
+	if !b.VerifySecret(secret) {
+		t.Error("Binding.VerifySecret() = false for the secret returned by New")
+	}
+	if b.VerifySecret(secret + "x") {
+		t.Error("Binding.VerifySecret() = true for a wrong secret")
+	}
+}
+
+func testDelete(t *testing.T, svc controller.BindingService) {
+	if err := svc.Delete("test"); !errors.Is(err, controller.ErrNotFound) {
+		t.Errorf("BindingService.Delete() on missing ID error = %v, want %v", err, controller.ErrNotFound)
+	}
+
+	mustNew(t, svc, "test")
+	if err := svc.Delete("test"); err != nil {
+		t.Fatalf("BindingService.Delete() error = %v", err)
+	}
+	if _, err := svc.Get("test"); !errors.Is(err, controller.ErrNotFound) {
+		t.Errorf("BindingService.Get() after Delete error = %v, want %v", err, controller.ErrNotFound)
+	}
+}
+
+func testList(t *testing.T, svc controller.BindingService) {
+	for _, id := range []string{"c", "a", "b"} {
+		mustNew(t, svc, id)
+	}
+	if err := mustGet(t, svc, "b").SetStatus("setup_required"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0)
+	opts := controller.ListOptions{Limit: 2}
+	for i := 0; i < 3; i++ {
+		page, cursor, err := svc.List(opts)
+		if err != nil {
+			t.Fatalf("BindingService.List() error = %v", err)
+		}
+		for _, b := range page {
+			ids = append(ids, b.ID())
+		}
+		if cursor == "" {
+			break
+		}
+		opts.Cursor = cursor
+	}
+	if fmt.Sprint(ids) != "[a b c]" {
+		t.Errorf("BindingService.List() = %v, want [a b c]", ids)
+	}
+
+	page, _, err := svc.List(controller.ListOptions{Status: "setup_required"})
+	if err != nil {
+		t.Fatalf("BindingService.List() error = %v", err)
+	}
+	if len(page) != 1 || page[0].ID() != "b" {
+		t.Errorf("BindingService.List() with status filter returned %d bindings, want [b]", len(page))
+	}
+}
+
+func testBind(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if err := b.Bind(&document.ControllerBinding{
+		AdminRoles:            []string{"admin@example.com", "service@example.com"},
+		ControllerCertificate: "abc",
+		Mandates:              []string{"def", "ghi"},
+		RealmDescriptor:       &document.RealmDescriptor{Name: "example.com"},
+	}); err != nil {
+		t.Fatalf("Binding.Bind() error = %v", err)
+	}
+
+	for _, b := range []controller.Binding{b, mustGet(t, svc, "test")} {
+		if b.Certificate() != "abc" {
+			t.Errorf("Binding.Certificate() = %v, want abc", b.Certificate())
+		}
+		if fmt.Sprint(b.AdminRoles()) != "[admin@example.com service@example.com]" {
+			t.Errorf("Binding.AdminRoles() = %v", b.AdminRoles())
+		}
+		if fmt.Sprint(b.Mandates()) != "[def ghi]" {
+			t.Errorf("Binding.Mandates() = %v", b.Mandates())
+		}
+		if b.Realm() == nil || b.Realm().Name != "example.com" {
+			t.Errorf("Binding.Realm() = %v", b.Realm())
+		}
+		if b.ControllerBinding() == nil || b.ControllerBinding().ControllerCertificate != "abc" {
+			t.Errorf("Binding.ControllerBinding() = %v", b.ControllerBinding())
+		}
+	}
+}
+
+func testUnbind(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if err := b.Unbind(); !errors.Is(err, controller.ErrNotBound) {
+		t.Errorf("Binding.Unbind() on unbound Binding error = %v, want %v", err, controller.ErrNotBound)
+	}
+
+	if err := b.Bind(&document.ControllerBinding{
+		AdminRoles:            []string{"admin"},
+		ControllerCertificate: "abc",
+		Mandates:              []string{"def"},
+		RealmDescriptor:       &document.RealmDescriptor{Name: "example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unbind(); err != nil {
+		t.Fatalf("Binding.Unbind() error = %v", err)
+	}
+
+	for _, b := range []controller.Binding{b, mustGet(t, svc, "test")} {
+		if b.Certificate() != "" || len(b.Mandates()) > 0 || len(b.AdminRoles()) > 0 || b.Realm() != nil || b.ControllerBinding() != nil {
+			t.Error("Binding still holds realm data after Unbind")
+		}
+	}
+}
+
+func testGenerateKey(t *testing.T, svc controller.BindingService) {
+	ksvc := keys.NewMockStoredKeyService()
+	kek := crypto.NewSymmetricKey(jose.A256KW)
+
+	b := mustNew(t, svc, "test")
+	if b.PublicKey() != nil {
+		t.Error("Binding.PublicKey() should be nil before GenerateKey")
+	}
+	if err := b.GenerateKey(ksvc, []byte("broken")); err == nil {
+		t.Error("Binding.GenerateKey() with broken KEK should fail")
+	}
+	if err := b.GenerateKey(ksvc, kek); err != nil {
+		t.Fatalf("Binding.GenerateKey() error = %v", err)
+	}
+
+	b = mustGet(t, svc, "test")
+	if b.PublicKey() == nil {
+		t.Fatal("Binding.PublicKey() is nil after GenerateKey")
+	}
+
+	key, err := b.PrivateKey(ksvc, kek)
+	if err != nil {
+		t.Fatalf("Binding.PrivateKey() error = %v", err)
+	}
+	pk, err := crypto.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crypto.Thumbprint(pk) != crypto.Thumbprint(b.PublicKey()) {
+		t.Error("Binding.PrivateKey() does not match Binding.PublicKey()")
+	}
+
+	if _, err := b.PrivateKey(ksvc, crypto.NewSymmetricKey(jose.A256KW)); err == nil {
+		t.Error("Binding.PrivateKey() with wrong KEK should fail")
+	}
+}
+
+func testKeyRotation(t *testing.T, svc controller.BindingService) {
+	ksvc := keys.NewMockStoredKeyService()
+	kek := crypto.NewSymmetricKey(jose.A256KW)
+
+	b := mustNew(t, svc, "test")
+	if len(b.PublicKeys()) != 0 {
+		t.Errorf("Binding.PublicKeys() = %d keys before GenerateKey, want 0", len(b.PublicKeys()))
+	}
+
+	thumbprints := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		if err := mustGet(t, svc, "test").GenerateKey(ksvc, kek); err != nil {
+			t.Fatalf("Binding.GenerateKey() error = %v", err)
+		}
+		thumbprints = append([]string{crypto.Thumbprint(mustGet(t, svc, "test").PublicKey())}, thumbprints...)
+	}
+
+	b = mustGet(t, svc, "test")
+	set := b.PublicKeys()
+	if len(set) != len(thumbprints) {
+		t.Fatalf("Binding.PublicKeys() = %d keys, want %d", len(set), len(thumbprints))
+	}
+	for i, k := range set {
+		if crypto.Thumbprint(k.Key) != thumbprints[i] {
+			t.Errorf("Binding.PublicKeys()[%d] is not the key generated %d rotations ago", i, i)
+		}
+		if k.Active() != (i == 0) {
+			t.Errorf("Binding.PublicKeys()[%d].Active() = %v", i, k.Active())
+		}
+		if k.Created.IsZero() {
+			t.Errorf("Binding.PublicKeys()[%d].Created is not set", i)
+		}
+	}
+
+	for _, k := range set[1:] {
+		skey, err := ksvc.Get(controller.RetiredKeyID("test", k.Key))
+		if err != nil {
+			t.Fatalf("retired private key not kept: %v", err)
+		}
+		key, err := skey.Decrypt(kek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, err := crypto.NewPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crypto.Thumbprint(pk) != crypto.Thumbprint(k.Key) {
+			t.Error("retired private key does not match the retired public key")
+		}
+	}
+
+	// only the key retired by this call is within the retention
+	if err := mustGet(t, svc, "test").GenerateKeyWithOptions(ksvc, kek, controller.KeyOptions{Retention: time.Nanosecond}); err != nil {
+		t.Fatalf("Binding.GenerateKeyWithOptions() error = %v", err)
+	}
+
+	pruned := mustGet(t, svc, "test").PublicKeys()
+	if len(pruned) != 2 || crypto.Thumbprint(pruned[1].Key) != thumbprints[0] {
+		t.Fatalf("Binding.PublicKeys() after pruning = %d keys, want the new and the previous key", len(pruned))
+	}
+	for _, k := range set[1:] {
+		if skey, err := ksvc.Get(controller.RetiredKeyID("test", k.Key)); err == nil && skey.Key != "" {
+			t.Error("private key of a pruned key is still stored")
+		}
+	}
+}
+
+func testKeyOptions(t *testing.T, svc controller.BindingService) {
+	ksvc := keys.NewMockStoredKeyService()
+	kek := crypto.NewSymmetricKey(jose.A256KW)
+
+	tests := []struct {
+		name    string
+		opts    controller.KeyOptions
+		wantAlg string
+		wantErr bool
+	}{
+		{"Default", controller.KeyOptions{}, "ES256", false},
+		{"Curve", controller.KeyOptions{Curve: "P-384"}, "ES384", false},
+		{"Algorithm", controller.KeyOptions{Algorithm: "ES512"}, "ES512", false},
+		{"RSA", controller.KeyOptions{Algorithm: "PS256", KeySize: 2048}, "PS256", false},
+		{"UnknownAlgorithm", controller.KeyOptions{Algorithm: "HS256"}, "", true},
+		{"EdDSA", controller.KeyOptions{Algorithm: "EdDSA"}, "", true},
+		{"Ed25519", controller.KeyOptions{Curve: "Ed25519"}, "", true},
+		{"CurveMismatch", controller.KeyOptions{Algorithm: "ES256", Curve: "P-384"}, "", true},
+		{"KeySizeWithCurve", controller.KeyOptions{Curve: "P-384", KeySize: 2048}, "", true},
+		{"KeySizeWithEC", controller.KeyOptions{Algorithm: "ES256", KeySize: 2048}, "", true},
+		{"ShortRSA", controller.KeyOptions{KeySize: 1024}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mustNew(t, svc, tt.name)
+			err := mustGet(t, svc, tt.name).GenerateKeyWithOptions(ksvc, kek, tt.opts)
+			if tt.wantErr {
+				if !errors.Is(err, controller.ErrUnsupportedKeyAlgorithm) {
+					t.Errorf("Binding.GenerateKeyWithOptions() error = %v, want %v", err, controller.ErrUnsupportedKeyAlgorithm)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Binding.GenerateKeyWithOptions() error = %v", err)
+			}
+
+			b := mustGet(t, svc, tt.name)
+			if alg := b.PublicKey().Algorithm; alg != tt.wantAlg {
+				t.Errorf("Binding.PublicKey().Algorithm = %v, want %v", alg, tt.wantAlg)
+			}
+			key, err := b.PrivateKey(ksvc, kek)
+			if err != nil {
+				t.Fatalf("Binding.PrivateKey() error = %v", err)
+			}
+			if key.Algorithm != tt.wantAlg {
+				t.Errorf("Binding.PrivateKey().Algorithm = %v, want %v", key.Algorithm, tt.wantAlg)
+			}
+		})
+	}
+}
+
+func testPostBind(t *testing.T, svc controller.BindingService) {
+	called := ""
+	svc.SetPostBind(func(b controller.Binding) {
+		called = b.ID()
+		b.SetStatus("setup_required")
+	})
+
+	b := mustNew(t, svc, "test")
+	if err := b.Bind(&document.ControllerBinding{}); err != nil {
+		t.Fatal(err)
+	}
+	if called != "test" {
+		t.Fatalf("PostBind called with %q, want test", called)
+	}
+	if status := mustGet(t, svc, "test").Status(); status != "setup_required" {
+		t.Errorf("Status after PostBind = %v, want setup_required", status)
+	}
+}
+
+func testPostUnbind(t *testing.T, svc controller.BindingService) {
+	called := ""
+	svc.SetPostUnbind(func(b controller.Binding) {
+		called = b.ID()
+		b.SetStatus("unbound")
+	})
+
+	b := mustNew(t, svc, "test")
+	if err := b.Bind(&document.ControllerBinding{}); err != nil {
+		t.Fatal(err)
+	}
+	if called != "" {
+		t.Fatal("PostUnbind called by Bind")
+	}
+	if err := b.Unbind(); err != nil {
+		t.Fatal(err)
+	}
+	if called != "test" {
+		t.Fatalf("PostUnbind called with %q, want test", called)
+	}
+	if status := mustGet(t, svc, "test").Status(); status != "unbound" {
+		t.Errorf("Status after PostUnbind = %v, want unbound", status)
+	}
+}
+
+func testDescriptor(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if err := b.SetDescriptor(document.ControllerDescriptor{AdminUI: "https://example.com/admin"}); err != nil {
+		t.Fatalf("Binding.SetDescriptor() error = %v", err)
+	}
+
+	desc := mustGet(t, svc, "test").Descriptor()
+	if desc.AdminUI != "https://example.com/admin" {
+		t.Errorf("Binding.Descriptor() = %+v", desc)
+	}
+}
+
+func testStatus(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if err := b.SetStatus("setup_required"); err != nil {
+		t.Fatalf("Binding.SetStatus() error = %v", err)
+	}
+	if status := mustGet(t, svc, "test").Status(); status != "setup_required" {
+		t.Errorf("Binding.Status() = %v, want setup_required", status)
+	}
+}
+
+func testBindEndpoint(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	if err := b.SetBindEndpoint("https://example.com/bind"); err != nil {
+		t.Fatalf("Binding.SetBindEndpoint() error = %v", err)
+	}
+	if v := mustGet(t, svc, "test").BindEndpoint(); v != "https://example.com/bind" {
+		t.Errorf("Binding.BindEndpoint() = %v, want https://example.com/bind", v)
+	}
+}
+
+func testRotateSecret(t *testing.T, svc controller.BindingService) {
+	b := mustNew(t, svc, "test")
+	first := b.Secret()
+
+	second, err := b.RotateSecret(time.Hour)
+	if err != nil {
+		t.Fatalf("Binding.RotateSecret() error = %v", err)
+	}
+	if second == "" || second == first || b.Secret() != second {
+		t.Errorf("Binding.RotateSecret() = %v, want a new secret returned by Secret()", second)
+	}
+
+	got := mustGet(t, svc, "test")
+	if !got.VerifySecret(second) {
+		t.Error("Binding.VerifySecret() = false for the rotated secret")
+	}
+	if !got.VerifySecret(first) {
+		t.Error("Binding.VerifySecret() = false for the previous secret within the grace period")
+	}
+
+	third, err := got.RotateSecret(0)
+	if err != nil {
+		t.Fatalf("Binding.RotateSecret() error = %v", err)
+	}
+
+	got = mustGet(t, svc, "test")
+	if !got.VerifySecret(third) {
+		t.Error("Binding.VerifySecret() = false for the rotated secret")
+	}
+	if got.VerifySecret(second) {
+		t.Error("Binding.VerifySecret() = true for the previous secret after the grace period")
+	}
+	if got.VerifySecret(first) {
+		t.Error("Binding.VerifySecret() = true for a secret rotated out twice")
+	}
+
+	if cb, ok := got.(controller.ContextBinding); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := cb.RotateSecretContext(ctx, 0); err == nil {
+			t.Fatal("Binding.RotateSecretContext() with canceled context should fail")
+		}
+		if !got.VerifySecret(third) {
+			t.Error("Binding.VerifySecret() = false for the current secret after a failed rotation")
+		}
+	}
+}
+
+func testConcurrency(t *testing.T, svc controller.BindingService) {
+	const workers = 8
+
+	mustNew(t, svc, "shared")
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, workers*4)
+	created := make(chan bool, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("binding-%d", i)
+			b, err := svc.New(id)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := b.SetStatus(id); err != nil {
+				errs <- err
+			}
+
+			shared, err := svc.Get("shared")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := shared.SetBindEndpoint(id); err != nil {
+				errs <- err
+			}
+
+			if _, _, err := svc.List(controller.ListOptions{}); err != nil {
+				errs <- err
+			}
+
+			if _, err := svc.New("contended"); err == nil {
+				created <- true
+			} else if err != controller.ErrAlreadyExists {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	close(created)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(created) != 1 {
+		t.Errorf("BindingService.New() of the same ID succeeded %d times, want 1", len(created))
+	}
+
+	for i := 0; i < workers; i++ {
+		id := fmt.Sprintf("binding-%d", i)
+		if status := mustGet(t, svc, id).Status(); status != id {
+			t.Errorf("Binding.Status() = %v, want %v", status, id)
+		}
+	}
+
+	all, _, err := svc.List(controller.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != workers+2 {
+		t.Errorf("BindingService.List() returned %d bindings, want %d", len(all), workers+2)
+	}
+}
@@ -0,0 +1,32 @@
+package controller
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when a Binding does not exist.
+	ErrNotFound = errors.New("Binding not found")
+
+	// ErrAlreadyExists is returned when creating a Binding with an ID that is already in use.
+	ErrAlreadyExists = errors.New("Binding already exists")
+
+	// ErrNotBound is returned when an operation requires the Binding to be bound to a realm.
+	ErrNotBound = errors.New("Binding is not bound to a realm")
+
+	// ErrUnsupportedKeyAlgorithm is returned when generating a key with KeyOptions that are not supported.
+	ErrUnsupportedKeyAlgorithm = errors.New("Unsupported key algorithm")
+
+	// ErrNoKey is returned when a private key operation is requested for a Binding without a key.
+	ErrNoKey = errors.New("Binding has no key")
+
+	// ErrKeyMismatch is returned when the stored private key of a Binding does not match its public key.
+	ErrKeyMismatch = errors.New("Stored key does not match the public key of the binding")
+
+	// ErrOrphanKey is returned when a private key is stored for a Binding that has no public key.
+	ErrOrphanKey = errors.New("Stored key of a binding without a public key")
+
+	// ErrNotSignedByRealm is returned when a message is not signed by the realm the Binding is bound to.
+	ErrNotSignedByRealm = errors.New("Not signed by realm")
+
+	// ErrReplayCacheFull is returned by a ReplayCache that can not record another token before some expire.
+	ErrReplayCacheFull = errors.New("Replay cache is full")
+)
@@ -0,0 +1,281 @@
+package file
+
+import (
+	"context"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// fileRecord is the stored form of a binding
+type fileRecord struct {
+	ID           string                        `json:"id"`
+	SecretHash   string                        `json:"secret"`
+	PrevSecret   string                        `json:"previousSecret,omitempty"`
+	PrevExpires  time.Time                     `json:"previousSecretExpires,omitempty"`
+	PublicKey    *jose.JsonWebKey              `json:"publicKey,omitempty"`
+	KeyCreated   time.Time                     `json:"keyCreated,omitempty"`
+	RetiredKeys  []controller.BindingKey       `json:"retiredKeys,omitempty"`
+	Descriptor   document.ControllerDescriptor `json:"descriptor"`
+	Binding      *document.ControllerBinding   `json:"binding,omitempty"`
+	Status       string                        `json:"status,omitempty"`
+	BindEndpoint string                        `json:"bindEndpoint,omitempty"`
+}
+
+type fileBinding struct {
+	svc    *fileBindingService
+	rec    fileRecord
+	secret string
+}
+
+// update applies f to the stored record and refreshes the binding with the result
+func (b *fileBinding) update(ctx context.Context, f func(*fileRecord)) error {
+	return b.svc.locked(ctx, func() error {
+		rec, err := b.svc.read(b.rec.ID)
+		if err != nil {
+			return err
+		}
+
+		f(rec)
+		if err := b.svc.write(rec); err != nil {
+			return err
+		}
+
+		b.rec = *rec
+
+		return nil
+	})
+}
+
+// ID returns the ID of the binding.
+func (b *fileBinding) ID() string {
+	return b.rec.ID
+}
+
+// Secret for the binding.
+func (b *fileBinding) Secret() string {
+	return b.secret
+}
+
+// VerifySecret reports if the secret matches the binding secret, in constant time.
+func (b *fileBinding) VerifySecret(secret string) bool {
+	return controller.VerifyRotatedSecret(b.rec.SecretHash, b.rec.PrevSecret, b.rec.PrevExpires, secret)
+}
+
+// RotateSecret replaces the binding secret, the previous secret is accepted for the grace period.
+func (b *fileBinding) RotateSecret(grace time.Duration) (string, error) {
+	return b.RotateSecretContext(context.Background(), grace)
+}
+
+// RotateSecretContext is like RotateSecret but with a context.
+func (b *fileBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(grace)
+	err = b.update(ctx, func(r *fileRecord) {
+		r.PrevSecret = r.SecretHash
+		r.PrevExpires = expires
+		r.SecretHash = hash
+	})
+	if err != nil {
+		return "", err
+	}
+	b.secret = secret
+
+	return secret, nil
+}
+
+// GenerateKey will generate a new keypair for this binding.
+func (b *fileBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyContext(context.Background(), svc, kek)
+}
+
+// GenerateKeyContext is like GenerateKey but with a context.
+func (b *fileBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyWithOptionsContext(ctx, svc, kek, controller.KeyOptions{})
+}
+
+// GenerateKeyWithOptions is like GenerateKey but generates the kind of key selected by the KeyOptions.
+func (b *fileBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return b.GenerateKeyWithOptionsContext(context.Background(), svc, kek, opts)
+}
+
+// GenerateKeyWithOptionsContext is like GenerateKeyWithOptions but with a context.
+func (b *fileBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	current := controller.BindingKey{Key: b.rec.PublicKey, Created: b.rec.KeyCreated}
+
+	return controller.ReplaceKey(svc, b.rec.ID, kek, opts, current, b.rec.RetiredKeys, func(k controller.BindingKey, retired []controller.BindingKey) error {
+		return b.update(ctx, func(rec *fileRecord) {
+			rec.PublicKey = k.Key
+			rec.KeyCreated = k.Created
+			rec.RetiredKeys = retired
+		})
+	})
+}
+
+// PublicKey of the binding.
+func (b *fileBinding) PublicKey() *jose.JsonWebKey {
+	return b.rec.PublicKey
+}
+
+// PublicKeys returns the current key followed by the retired keys, newest first.
+func (b *fileBinding) PublicKeys() []controller.BindingKey {
+	return controller.KeySet(controller.BindingKey{Key: b.rec.PublicKey, Created: b.rec.KeyCreated}, b.rec.RetiredKeys)
+}
+
+// PrivateKey of the binding. Requires a StoredKeyService and a Key Encryption Key (KEK).
+func (b *fileBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.JsonWebKey, error) {
+	skey, err := svc.Get(b.rec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return skey.Decrypt(kek)
+}
+
+// Signer returns a Signer for the private key operations of the binding from the KeyStore.
+func (b *fileBinding) Signer(ks controller.KeyStore) (controller.Signer, error) {
+	return ks.Signer(b)
+}
+
+// Descriptor returns the ControllerDescriptor for this binding
+func (b *fileBinding) Descriptor() document.ControllerDescriptor {
+	return b.rec.Descriptor
+}
+
+// SetDescriptor sets the ControllerDescriptor for this binding
+func (b *fileBinding) SetDescriptor(desc document.ControllerDescriptor) error {
+	return b.SetDescriptorContext(context.Background(), desc)
+}
+
+// SetDescriptorContext is like SetDescriptor but with a context.
+func (b *fileBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
+	return b.update(ctx, func(rec *fileRecord) {
+		rec.Descriptor = desc
+	})
+}
+
+// Bind is used when the realm binds to this binding.
+func (b *fileBinding) Bind(c *document.ControllerBinding) error {
+	return b.BindContext(context.Background(), c)
+}
+
+// BindContext is like Bind but with a context.
+func (b *fileBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
+	if err := b.update(ctx, func(rec *fileRecord) {
+		rec.Binding = c
+	}); err != nil {
+		return err
+	}
+
+	if b.svc.postBindFunc != nil {
+		b.svc.postBindFunc(b)
+	}
+
+	return nil
+}
+
+// Unbind removes the realm binding
+func (b *fileBinding) Unbind() error {
+	return b.UnbindContext(context.Background())
+}
+
+// UnbindContext is like Unbind but with a context.
+func (b *fileBinding) UnbindContext(ctx context.Context) error {
+	if b.rec.Binding == nil {
+		return controller.ErrNotBound
+	}
+
+	if err := b.update(ctx, func(rec *fileRecord) {
+		rec.Binding = nil
+	}); err != nil {
+		return err
+	}
+
+	if b.svc.postUnbindFunc != nil {
+		b.svc.postUnbindFunc(b)
+	}
+
+	return nil
+}
+
+// Certificate of the binding.
+func (b *fileBinding) Certificate() string {
+	if b.rec.Binding == nil {
+		return ""
+	}
+
+	return b.rec.Binding.ControllerCertificate
+}
+
+// Mandates returns the mandates we got from the realm.
+func (b *fileBinding) Mandates() []string {
+	if b.rec.Binding == nil {
+		return []string{}
+	}
+
+	return b.rec.Binding.Mandates
+}
+
+// AdminRoles returns the list of roles that can administer this binding.
+func (b *fileBinding) AdminRoles() []string {
+	if b.rec.Binding == nil {
+		return []string{}
+	}
+
+	return b.rec.Binding.AdminRoles
+}
+
+// Realm returns the realm-descriptor that was used for this binding.
+func (b *fileBinding) Realm() *document.RealmDescriptor {
+	if b.rec.Binding == nil {
+		return nil
+	}
+
+	return b.rec.Binding.RealmDescriptor
+}
+
+// ControllerBinding returns the controller-binding document.
+func (b *fileBinding) ControllerBinding() *document.ControllerBinding {
+	return b.rec.Binding
+}
+
+// Status is used to tell the realm if this binding requires some extra setup steps.
+func (b *fileBinding) Status() string {
+	return b.rec.Status
+}
+
+// SetStatus updates the Status value.
+func (b *fileBinding) SetStatus(v string) error {
+	return b.SetStatusContext(context.Background(), v)
+}
+
+// SetStatusContext is like SetStatus but with a context.
+func (b *fileBinding) SetStatusContext(ctx context.Context, v string) error {
+	return b.update(ctx, func(rec *fileRecord) {
+		rec.Status = v
+	})
+}
+
+// BindEndpoint returns the endpoint where the realm should post the controller-binding.
+func (b *fileBinding) BindEndpoint() string {
+	return b.rec.BindEndpoint
+}
+
+// SetBindEndpoint updates the BindEndpoint value.
+func (b *fileBinding) SetBindEndpoint(v string) error {
+	return b.SetBindEndpointContext(context.Background(), v)
+}
+
+// SetBindEndpointContext is like SetBindEndpoint but with a context.
+func (b *fileBinding) SetBindEndpointContext(ctx context.Context, v string) error {
+	return b.update(ctx, func(rec *fileRecord) {
+		rec.BindEndpoint = v
+	})
+}
@@ -0,0 +1,314 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/gofrs/flock"
+)
+
+// only files with fileExt are bindings, the temporary and lock files never end with it
+const (
+	fileExt  = ".json"
+	tmpExt   = ".tmp"
+	lockFile = ".lock"
+)
+
+type fileBindingService struct {
+	dir            string
+	mu             sync.Mutex
+	lock           *flock.Flock
+	postBindFunc   func(controller.Binding)
+	postUnbindFunc func(controller.Binding)
+}
+
+// New returns a BindingService that stores each binding as a JSON file in dir.
+// Files are replaced atomically on every change and a lock file in dir
+// prevents several processes from writing at the same time.
+func New(dir string) (controller.ContextBindingService, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &fileBindingService{
+		dir:  dir,
+		lock: flock.New(filepath.Join(dir, lockFile)),
+	}, nil
+}
+
+// locked runs f while holding both the process and the file lock
+func (s *fileBindingService) locked(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.lock.Lock(); err != nil {
+		return err
+	}
+	defer s.lock.Unlock()
+
+	return f()
+}
+
+func (s *fileBindingService) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+fileExt)
+}
+
+// read loads the record for id, must be called while holding the lock
+func (s *fileBindingService) read(id string) (*fileRecord, error) {
+	bytes, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, controller.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &fileRecord{}
+	if err := json.Unmarshal(bytes, rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// write stores the record by writing it to a temporary file that is renamed over the old one,
+// must be called while holding the lock
+func (s *fileBindingService) write(rec *fileRecord) error {
+	bytes, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, "*"+tmpExt)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bytes); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path(rec.ID)); err != nil {
+		return err
+	}
+
+	return s.syncDir()
+}
+
+// syncDir flushes the directory entries, so a rename survives a crash
+func (s *fileBindingService) syncDir() error {
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// ids returns the IDs of all stored bindings in sorted order, must be called while holding the lock
+func (s *fileBindingService) ids() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+
+		id, err := url.PathUnescape(strings.TrimSuffix(name, fileExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+func (s *fileBindingService) binding(rec *fileRecord) *fileBinding {
+	return &fileBinding{
+		svc: s,
+		rec: *rec,
+	}
+}
+
+func (s *fileBindingService) SetPostBind(f func(controller.Binding)) {
+	s.postBindFunc = f
+}
+
+func (s *fileBindingService) PostBind() func(controller.Binding) {
+	return s.postBindFunc
+}
+
+func (s *fileBindingService) SetPostUnbind(f func(controller.Binding)) {
+	s.postUnbindFunc = f
+}
+
+func (s *fileBindingService) PostUnbind() func(controller.Binding) {
+	return s.postUnbindFunc
+}
+
+func (s *fileBindingService) New(id string) (controller.Binding, error) {
+	return s.NewContext(context.Background(), id)
+}
+
+func (s *fileBindingService) NewContext(ctx context.Context, id string) (controller.Binding, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &fileRecord{
+		ID:         id,
+		SecretHash: hash,
+	}
+
+	err = s.locked(ctx, func() error {
+		existing, err := s.read(id)
+		if err == nil {
+			rec = existing
+			return controller.ErrAlreadyExists
+		}
+		if err != controller.ErrNotFound {
+			return err
+		}
+
+		return s.write(rec)
+	})
+	if err == controller.ErrAlreadyExists {
+		return s.binding(rec), err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := s.binding(rec)
+	b.secret = secret
+
+	return b, nil
+}
+
+func (s *fileBindingService) Get(id string) (controller.Binding, error) {
+	return s.GetContext(context.Background(), id)
+}
+
+func (s *fileBindingService) GetContext(ctx context.Context, id string) (controller.Binding, error) {
+	var rec *fileRecord
+	err := s.locked(ctx, func() error {
+		var err error
+		rec, err = s.read(id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.binding(rec), nil
+}
+
+func (s *fileBindingService) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *fileBindingService) DeleteContext(ctx context.Context, id string) error {
+	return s.locked(ctx, func() error {
+		err := os.Remove(s.path(id))
+		if os.IsNotExist(err) {
+			return controller.ErrNotFound
+		}
+
+		return err
+	})
+}
+
+func (s *fileBindingService) List(opts controller.ListOptions) ([]controller.Binding, string, error) {
+	return s.ListContext(context.Background(), opts)
+}
+
+func (s *fileBindingService) ListContext(ctx context.Context, opts controller.ListOptions) ([]controller.Binding, string, error) {
+	bindings := make([]controller.Binding, 0)
+	cursor := ""
+	err := s.locked(ctx, func() error {
+		ids, err := s.ids()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if id <= opts.Cursor {
+				continue
+			}
+
+			rec, err := s.read(id)
+			if err != nil {
+				return err
+			}
+
+			b := s.binding(rec)
+			if !opts.Match(b) {
+				continue
+			}
+
+			if opts.Limit > 0 && len(bindings) == opts.Limit {
+				cursor = bindings[len(bindings)-1].ID()
+				return nil
+			}
+
+			bindings = append(bindings, b)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return bindings, cursor, nil
+}
+
+func (s *fileBindingService) FindByRealm(thumbprint string) ([]controller.Binding, error) {
+	return s.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (s *fileBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]controller.Binding, error) {
+	bindings, _, err := s.ListContext(ctx, controller.ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (s *fileBindingService) FindByRealmName(name string) ([]controller.Binding, error) {
+	return s.FindByRealmNameContext(context.Background(), name)
+}
+
+func (s *fileBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]controller.Binding, error) {
+	bindings, _, err := s.ListContext(ctx, controller.ListOptions{Realm: name})
+	return bindings, err
+}
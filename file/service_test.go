@@ -0,0 +1,84 @@
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+)
+
+func Test_fileBindingService_Files(t *testing.T) {
+	dir := t.TempDir()
+	svc, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := svc.New("realm/binding")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetStatus("setup_required"); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if len(names) != 2 || names[0] != lockFile || names[1] != "realm%2Fbinding.json" {
+		t.Fatalf("files in directory = %v, want [%s realm%%2Fbinding.json]", names, lockFile)
+	}
+
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &fileRecord{}
+	if err := json.Unmarshal(bytes, rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ID != "realm/binding" || rec.Status != "setup_required" {
+		t.Errorf("stored record = %+v", rec)
+	}
+
+	ids, err := svc.(*fileBindingService).ids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "realm/binding" {
+		t.Errorf("ids() = %v, want [realm/binding]", ids)
+	}
+}
+
+func Test_fileBindingService_DotID(t *testing.T) {
+	svc, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{".hidden", ".lock", ".tmp"} {
+		if _, err := svc.New(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, _, err := svc.List(controller.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0)
+	for _, b := range got {
+		ids = append(ids, b.ID())
+	}
+	if strings.Join(ids, ",") != ".hidden,.lock,.tmp" {
+		t.Errorf("List() = %v, want [.hidden .lock .tmp]", ids)
+	}
+}
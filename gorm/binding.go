@@ -1,8 +1,10 @@
 package gorm
 
 import (
+	"context"
 	"encoding/json"
 	"strings"
+	"time"
 
 	controller "github.com/Brickchain/go-controller.v2"
 	"github.com/Brickchain/go-crypto.v2"
@@ -14,34 +16,84 @@ import (
 
 type gormBinding struct {
 	db             *gorm.DB
-	DBid           string `gorm:"column:id;primary_key"`
-	DBsecret       string `gorm:"column:secret"`
-	DBpublicKey    string `gorm:"column:public_key"`
-	DBdescriptor   string `gorm:"column:descriptor"`
-	DBbinding      string `gorm:"column:binding"`
-	DBcertificate  string `gorm:"column:certificate"`
-	DBmandates     string `gorm:"column:mandates"`
-	DBadminRoles   string `gorm:"column:admin_roles"`
-	DBrealm        string `gorm:"column:realm"`
-	DBstatus       string `gorm:"column:status"`
-	DBbindEndpoint string `gorm:"column:bind_endpoint"`
+	DBid           string    `gorm:"column:id;primary_key"`
+	DBsecret       string    `gorm:"column:secret"`
+	DBprevSecret   string    `gorm:"column:previous_secret"`
+	DBprevExpires  time.Time `gorm:"column:previous_secret_expires"`
+	DBhashed       bool      `gorm:"column:secrets_hashed"`
+	DBpublicKey    string    `gorm:"column:public_key"`
+	DBkeyCreated   time.Time `gorm:"column:key_created"`
+	DBretiredKeys  string    `gorm:"column:retired_keys"`
+	DBdescriptor   string    `gorm:"column:descriptor"`
+	DBbinding      string    `gorm:"column:binding"`
+	DBcertificate  string    `gorm:"column:certificate"`
+	DBmandates     string    `gorm:"column:mandates"`
+	DBadminRoles   string    `gorm:"column:admin_roles"`
+	DBrealm        string    `gorm:"column:realm"`
+	DBrealmName    string    `gorm:"column:realm_name;index"`
+	DBrealmKeyTP   string    `gorm:"column:realm_thumbprint;index"`
+	DBstatus       string    `gorm:"column:status"`
+	DBbindEndpoint string    `gorm:"column:bind_endpoint"`
 	postBindFunc   func(controller.Binding)
 	postUnbindFunc func(controller.Binding)
+	kek            []byte
+	secret         string
 }
 
-func newGormBinding(db *gorm.DB, id string, postBindFunc, postUnbindFunc func(controller.Binding)) *gormBinding {
-	secret, _ := crypto.GenerateRandomString(42)
+func newGormBinding(db *gorm.DB, id string, postBindFunc, postUnbindFunc func(controller.Binding)) (*gormBinding, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
 	return &gormBinding{
 		db:             db,
 		DBid:           id,
-		DBsecret:       secret,
+		DBsecret:       hash,
 		postBindFunc:   postBindFunc,
 		postUnbindFunc: postUnbindFunc,
+		secret:         secret,
+	}, nil
+}
+
+func (g *gormBinding) save(ctx context.Context) error {
+	row, err := g.row()
+	if err != nil {
+		return err
+	}
+
+	return scoped(ctx, g.db, func(db *gorm.DB) error {
+		return db.Save(row).Error
+	})
+}
+
+// create inserts the binding as a new row, failing if a row with the ID exists
+func (g *gormBinding) create(ctx context.Context) error {
+	row, err := g.row()
+	if err != nil {
+		return err
 	}
+
+	return scoped(ctx, g.db, func(db *gorm.DB) error {
+		return db.Create(row).Error
+	})
 }
 
-func (g *gormBinding) save() error {
-	return g.db.Save(g).Error
+// row returns the binding as it is written to the database
+func (g *gormBinding) row() (*gormBinding, error) {
+	// secrets stored in plaintext before hashing was introduced are hashed on the next write
+	for _, v := range []*string{&g.DBsecret, &g.DBprevSecret} {
+		if *v != "" && !controller.IsSecretHash(*v) {
+			hash, err := controller.HashSecret(*v)
+			if err != nil {
+				return nil, err
+			}
+			*v = hash
+		}
+	}
+	g.DBhashed = true
+
+	return g.encrypted()
 }
 
 // ID returns the ID of the binding.
@@ -51,38 +103,61 @@ func (g *gormBinding) ID() string {
 
 // Secret for the binding.
 func (g *gormBinding) Secret() string {
-	return g.DBsecret
+	return g.secret
 }
 
-// GenerateKey will generate a new keypair for this binding.
-func (g *gormBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
-	key, err := crypto.NewKey()
-	if err != nil {
-		return err
-	}
+// VerifySecret reports if the secret matches the binding secret, in constant time.
+func (g *gormBinding) VerifySecret(secret string) bool {
+	return controller.VerifyRotatedSecret(g.DBsecret, g.DBprevSecret, g.DBprevExpires, secret)
+}
+
+// RotateSecret replaces the binding secret, the previous secret is accepted for the grace period.
+func (g *gormBinding) RotateSecret(grace time.Duration) (string, error) {
+	return g.RotateSecretContext(context.Background(), grace)
+}
 
-	pk, err := crypto.NewPublicKey(key)
+// RotateSecretContext is like RotateSecret but with a context.
+func (g *gormBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	secret, hash, err := controller.NewSecret()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	skey := &keys.StoredKey{
-		ID: g.DBid,
-	}
+	prevSecret, prevExpires, current := g.DBprevSecret, g.DBprevExpires, g.DBsecret
 
-	if err = skey.Encrypt(key, kek); err != nil {
-		return err
-	}
+	g.DBprevSecret = g.DBsecret
+	g.DBprevExpires = time.Now().Add(grace)
+	g.DBsecret = hash
 
-	if err = svc.Save(skey); err != nil {
-		return err
+	if err = g.save(ctx); err != nil {
+		g.DBprevSecret, g.DBprevExpires, g.DBsecret = prevSecret, prevExpires, current
+		return "", err
 	}
+	g.secret = secret
 
-	if err = g.setPublicKey(pk); err != nil {
-		return err
-	}
+	return secret, nil
+}
+
+// GenerateKey will generate a new keypair for this binding.
+func (g *gormBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return g.GenerateKeyContext(context.Background(), svc, kek)
+}
+
+// GenerateKeyContext is like GenerateKey but with a context.
+func (g *gormBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	return g.GenerateKeyWithOptionsContext(ctx, svc, kek, controller.KeyOptions{})
+}
 
-	return svc.Save(skey)
+// GenerateKeyWithOptions is like GenerateKey but generates the kind of key selected by the KeyOptions.
+func (g *gormBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return g.GenerateKeyWithOptionsContext(context.Background(), svc, kek, opts)
+}
+
+// GenerateKeyWithOptionsContext is like GenerateKeyWithOptions but with a context.
+func (g *gormBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return controller.ReplaceKey(svc, g.DBid, kek, opts, g.currentKey(), g.retiredKeys(), func(k controller.BindingKey, retired []controller.BindingKey) error {
+		return g.setPublicKey(ctx, k.Key, k.Created, retired)
+	})
 }
 
 // PublicKey of the binding.
@@ -91,15 +166,54 @@ func (g *gormBinding) PublicKey() *jose.JsonWebKey {
 	return key
 }
 
-func (g *gormBinding) setPublicKey(key *jose.JsonWebKey) error {
+// PublicKeys returns the current key followed by the retired keys, newest first.
+func (g *gormBinding) PublicKeys() []controller.BindingKey {
+	return controller.KeySet(g.currentKey(), g.retiredKeys())
+}
+
+func (g *gormBinding) currentKey() controller.BindingKey {
+	if g.DBpublicKey == "" {
+		return controller.BindingKey{}
+	}
+
+	return controller.BindingKey{
+		Key:     g.PublicKey(),
+		Created: g.DBkeyCreated,
+	}
+}
+
+func (g *gormBinding) retiredKeys() []controller.BindingKey {
+	retired := make([]controller.BindingKey, 0)
+	if g.DBretiredKeys != "" {
+		json.Unmarshal([]byte(g.DBretiredKeys), &retired)
+	}
+
+	return retired
+}
+
+func (g *gormBinding) setPublicKey(ctx context.Context, key *jose.JsonWebKey, created time.Time, retired []controller.BindingKey) error {
 	bytes, err := json.Marshal(key)
 	if err != nil {
 		return err
 	}
 
+	retiredBytes, err := json.Marshal(retired)
+	if err != nil {
+		return err
+	}
+
+	prevKey, prevCreated, prevRetired := g.DBpublicKey, g.DBkeyCreated, g.DBretiredKeys
+
 	g.DBpublicKey = string(bytes)
+	g.DBkeyCreated = created
+	g.DBretiredKeys = string(retiredBytes)
 
-	return g.save()
+	if err = g.save(ctx); err != nil {
+		g.DBpublicKey, g.DBkeyCreated, g.DBretiredKeys = prevKey, prevCreated, prevRetired
+		return err
+	}
+
+	return nil
 }
 
 // PrivateKey of the binding. Requires a StoredKeyService and a Key Encryption Key (KEK).
@@ -112,6 +226,11 @@ func (g *gormBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.J
 	return skey.Decrypt(kek)
 }
 
+// Signer returns a Signer for the private key operations of the binding from the KeyStore.
+func (g *gormBinding) Signer(ks controller.KeyStore) (controller.Signer, error) {
+	return ks.Signer(g)
+}
+
 func (g *gormBinding) Descriptor() document.ControllerDescriptor {
 	desc := document.ControllerDescriptor{}
 	json.Unmarshal([]byte(g.DBdescriptor), &desc)
@@ -120,20 +239,36 @@ func (g *gormBinding) Descriptor() document.ControllerDescriptor {
 }
 
 func (g *gormBinding) SetDescriptor(desc document.ControllerDescriptor) error {
+	return g.SetDescriptorContext(context.Background(), desc)
+}
+
+// SetDescriptorContext is like SetDescriptor but with a context.
+func (g *gormBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
 	bytes, err := json.Marshal(desc)
 	if err != nil {
 		return err
 	}
 
 	g.DBdescriptor = string(bytes)
-	return g.save()
+	return g.save(ctx)
 }
 
 // Bind is used when the realm binds to this binding.
 func (g *gormBinding) Bind(c *document.ControllerBinding) error {
+	return g.BindContext(context.Background(), c)
+}
+
+// BindContext is like Bind but with a context.
+func (g *gormBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
 	g.DBcertificate = c.ControllerCertificate
-	g.DBmandates = strings.Join(c.Mandates, ",")
-	g.setAdminRoles(c.AdminRoles)
+
+	if err := g.setMandates(c.Mandates); err != nil {
+		return err
+	}
+
+	if err := g.setAdminRoles(c.AdminRoles); err != nil {
+		return err
+	}
 
 	if err := g.setRealm(c.RealmDescriptor); err != nil {
 		return err
@@ -143,7 +278,7 @@ func (g *gormBinding) Bind(c *document.ControllerBinding) error {
 		return err
 	}
 
-	if err := g.save(); err != nil {
+	if err := g.save(ctx); err != nil {
 		return err
 	}
 
@@ -156,17 +291,28 @@ func (g *gormBinding) Bind(c *document.ControllerBinding) error {
 
 // Unbind removes the realm binding
 func (g *gormBinding) Unbind() error {
+	return g.UnbindContext(context.Background())
+}
+
+// UnbindContext is like Unbind but with a context.
+func (g *gormBinding) UnbindContext(ctx context.Context) error {
+	if g.DBbinding == "" {
+		return controller.ErrNotBound
+	}
+
 	g.DBcertificate = ""
 	g.DBmandates = ""
 	g.DBadminRoles = ""
 	g.DBrealm = ""
+	g.DBrealmName = ""
+	g.DBrealmKeyTP = ""
 	g.DBbinding = ""
 
 	if g.postUnbindFunc != nil {
 		g.postUnbindFunc(g)
 	}
 
-	return g.save()
+	return g.save(ctx)
 }
 
 // CertificateChain of the binding.
@@ -176,22 +322,68 @@ func (g *gormBinding) Certificate() string {
 
 // Mandate returns the mandate we got from the realm.
 func (g *gormBinding) Mandates() []string {
-	if g.DBmandates == "" {
-		return []string{}
+	return decodeList(g.DBmandates)
+}
+
+func (g *gormBinding) setMandates(mandates []string) error {
+	v, err := encodeList(mandates)
+	if err != nil {
+		return err
 	}
-	return strings.Split(g.DBmandates, ",")
+
+	g.DBmandates = v
+
+	return nil
 }
 
 // AdminRoles returns the list of roles that can administer this binding.
 func (g *gormBinding) AdminRoles() []string {
-	if g.DBadminRoles == "" {
+	return decodeList(g.DBadminRoles)
+}
+
+func (g *gormBinding) setAdminRoles(roles []string) error {
+	v, err := encodeList(roles)
+	if err != nil {
+		return err
+	}
+
+	g.DBadminRoles = v
+
+	return nil
+}
+
+// encodeList stores a list as a JSON array, empty lists are stored as an empty string
+func encodeList(l []string) (string, error) {
+	if len(l) == 0 {
+		return "", nil
+	}
+
+	bytes, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+// decodeList reads a list stored by encodeList
+func decodeList(v string) []string {
+	if v == "" {
 		return []string{}
 	}
-	return strings.Split(g.DBadminRoles, ",")
+
+	l := make([]string, 0)
+	if err := json.Unmarshal([]byte(v), &l); err != nil {
+		// comma-joined value from before the migration to JSON arrays
+		return strings.Split(v, ",")
+	}
+
+	return l
 }
 
-func (g *gormBinding) setAdminRoles(roles []string) {
-	g.DBadminRoles = strings.Join(roles, ",")
+// isLegacyList reports if the value was stored as a comma-joined string
+func isLegacyList(v string) bool {
+	return v != "" && !strings.HasPrefix(v, "[")
 }
 
 // Realm returns the realm-descriptor that was used for this binding.
@@ -211,6 +403,14 @@ func (g *gormBinding) setRealm(realm *document.RealmDescriptor) error {
 	}
 
 	g.DBrealm = string(bytes)
+	g.DBrealmName = ""
+	g.DBrealmKeyTP = ""
+	if realm != nil {
+		g.DBrealmName = realm.Name
+		if realm.PublicKey != nil {
+			g.DBrealmKeyTP = crypto.Thumbprint(realm.PublicKey)
+		}
+	}
 
 	return nil
 }
@@ -243,9 +443,14 @@ func (g *gormBinding) Status() string {
 
 // SetStatus updates the Status value.
 func (g *gormBinding) SetStatus(v string) error {
+	return g.SetStatusContext(context.Background(), v)
+}
+
+// SetStatusContext is like SetStatus but with a context.
+func (g *gormBinding) SetStatusContext(ctx context.Context, v string) error {
 	g.DBstatus = v
 
-	return g.save()
+	return g.save(ctx)
 }
 
 // BindEndpoint returns the endpoint where the realm should post the controller-binding.
@@ -255,7 +460,12 @@ func (g *gormBinding) BindEndpoint() string {
 
 // SetBindEndpoint updates the BindEndpoint value.
 func (g *gormBinding) SetBindEndpoint(v string) error {
+	return g.SetBindEndpointContext(context.Background(), v)
+}
+
+// SetBindEndpointContext is like SetBindEndpoint but with a context.
+func (g *gormBinding) SetBindEndpointContext(ctx context.Context, v string) error {
 	g.DBbindEndpoint = v
 
-	return g.save()
+	return g.save(ctx)
 }
@@ -0,0 +1,99 @@
+package gorm
+
+import (
+	"errors"
+	"strings"
+
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// encryptedPrefix marks column values that are stored as a JWE encrypted with the KEK
+const encryptedPrefix = "enc:"
+
+// ErrNoKEK is returned when an encrypted binding is loaded by a service without a Key Encryption Key
+var ErrNoKEK = errors.New("Binding is encrypted but no KEK was given")
+
+// encryptValue encrypts v with the Key Encryption Key, empty values are stored as is
+func encryptValue(v string, kek []byte) (string, error) {
+	if v == "" || kek == nil {
+		return v, nil
+	}
+
+	enc, err := jose.NewEncrypter(jose.A256KW, jose.A256GCM, kek)
+	if err != nil {
+		return "", err
+	}
+
+	obj, err := enc.Encrypt([]byte(v))
+	if err != nil {
+		return "", err
+	}
+
+	s, err := obj.CompactSerialize()
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + s, nil
+}
+
+// decryptValue decrypts a value stored by encryptValue, plaintext values are returned as is
+func decryptValue(v string, kek []byte) (string, error) {
+	if !strings.HasPrefix(v, encryptedPrefix) {
+		return v, nil
+	}
+
+	if kek == nil {
+		return "", ErrNoKEK
+	}
+
+	obj, err := jose.ParseEncrypted(strings.TrimPrefix(v, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := obj.Decrypt(kek)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+// sensitive returns the columns that are encrypted at rest
+func (g *gormBinding) sensitive() []*string {
+	return []*string{
+		&g.DBsecret,
+		&g.DBprevSecret,
+		&g.DBbinding,
+		&g.DBcertificate,
+		&g.DBmandates,
+	}
+}
+
+// encrypted returns a copy of the binding with the sensitive columns encrypted
+func (g *gormBinding) encrypted() (*gormBinding, error) {
+	e := *g
+	for _, f := range e.sensitive() {
+		v, err := encryptValue(*f, g.kek)
+		if err != nil {
+			return nil, err
+		}
+		*f = v
+	}
+
+	return &e, nil
+}
+
+// decrypt decrypts the sensitive columns of a binding loaded from the database
+func (g *gormBinding) decrypt() error {
+	for _, f := range g.sensitive() {
+		v, err := decryptValue(*f, g.kek)
+		if err != nil {
+			return err
+		}
+		*f = v
+	}
+
+	return nil
+}
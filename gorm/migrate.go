@@ -0,0 +1,153 @@
+package gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MigrationError is returned by Migrate when some bindings could not be converted. The bindings
+// are left as they were and the conversion is retried on the next migration.
+type MigrationError struct {
+	// Failed holds the error for each binding ID that could not be converted.
+	Failed map[string]error
+}
+
+func (e *MigrationError) Error() string {
+	ids := make([]string, 0, len(e.Failed))
+	for id := range e.Failed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	failed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		failed = append(failed, fmt.Sprintf("%s (%s)", id, e.Failed[id]))
+	}
+
+	return fmt.Sprintf("failed to migrate %d bindings: %s", len(ids), strings.Join(failed, ", "))
+}
+
+// Migrate creates or updates the bindings table and converts the bindings stored by earlier versions,
+// encrypting them when a Key Encryption Key (KEK) is given. New and NewEncrypted run the same migrations
+// but can not report errors, so call Migrate before them to find bindings that could not be converted.
+// A database error aborts the migration, bindings that fail to load or save are returned in a *MigrationError.
+func Migrate(ctx context.Context, db *gorm.DB, kek []byte) error {
+	g := &gormBindingService{
+		db:  db,
+		kek: kek,
+	}
+
+	return g.migrate(ctx)
+}
+
+// migrate runs the migrations, collecting the bindings that fail to convert
+func (g *gormBindingService) migrate(ctx context.Context) error {
+	if err := g.db.AutoMigrate(&gormBinding{}).Error; err != nil {
+		return err
+	}
+
+	failed := make(map[string]error)
+	for _, m := range []func(context.Context, map[string]error) error{
+		g.migrateRealmIndex,
+		g.migrateLists,
+		g.migrateSecrets,
+		g.migrateEncryption,
+	} {
+		if err := m(ctx, failed); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return &MigrationError{Failed: failed}
+	}
+
+	return nil
+}
+
+// migrateRows loads the bindings matching the query with args and calls f for each of them,
+// recording the bindings that fail to load or convert in failed
+func (g *gormBindingService) migrateRows(ctx context.Context, failed map[string]error, f func(*gormBinding) error, query string, args ...interface{}) error {
+	bindings := make([]*gormBinding, 0)
+	err := scoped(ctx, g.db, func(db *gorm.DB) error {
+		return db.Where(query, args...).Find(&bindings).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bindings {
+		if _, ok := failed[b.DBid]; ok {
+			continue
+		}
+
+		err := g.load(b)
+		if err == nil {
+			err = f(b)
+		}
+		if err != nil {
+			failed[b.DBid] = err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// migrateRealmIndex populates the realm_name and realm_thumbprint columns for bindings that were bound before they existed
+func (g *gormBindingService) migrateRealmIndex(ctx context.Context, failed map[string]error) error {
+	return g.migrateRows(ctx, failed, func(b *gormBinding) error {
+		realm := b.Realm()
+		if realm == nil {
+			return nil
+		}
+
+		b.setRealm(realm)
+		return b.save(ctx)
+	}, "realm <> '' AND (realm_name IS NULL OR realm_thumbprint IS NULL OR (realm_name = '' AND realm_thumbprint = ''))")
+}
+
+// migrateLists converts mandates and admin roles stored as comma-joined strings to JSON arrays.
+// The lists are restored from the stored controller-binding when possible, since splitting on
+// commas breaks mandates and roles that contain commas.
+func (g *gormBindingService) migrateLists(ctx context.Context, failed map[string]error) error {
+	return g.migrateRows(ctx, failed, func(b *gormBinding) error {
+		mandates, roles := b.Mandates(), b.AdminRoles()
+		if c := b.ControllerBinding(); c != nil {
+			mandates, roles = c.Mandates, c.AdminRoles
+		}
+
+		if isLegacyList(b.DBmandates) {
+			b.setMandates(mandates)
+		}
+		if isLegacyList(b.DBadminRoles) {
+			b.setAdminRoles(roles)
+		}
+
+		return b.save(ctx)
+	}, "(mandates <> '' AND mandates NOT LIKE '[%' AND mandates NOT LIKE 'enc:%') OR (admin_roles <> '' AND admin_roles NOT LIKE '[%')")
+}
+
+// migrateSecrets replaces secrets stored in plaintext with a salted hash. The bindings written before
+// secrets were hashed are the ones without secrets_hashed set, their secrets are decrypted to find the
+// plaintext secrets that were encrypted before hashing was introduced. Every binding is saved, so it
+// is marked and not loaded again by the next migration.
+func (g *gormBindingService) migrateSecrets(ctx context.Context, failed map[string]error) error {
+	return g.migrateRows(ctx, failed, func(b *gormBinding) error {
+		return b.save(ctx)
+	}, "(secrets_hashed IS NULL OR secrets_hashed = ?) AND (secret <> '' OR previous_secret <> '')", false)
+}
+
+// migrateEncryption encrypts the sensitive columns of bindings that are stored in plaintext
+func (g *gormBindingService) migrateEncryption(ctx context.Context, failed map[string]error) error {
+	if g.kek == nil {
+		return nil
+	}
+
+	return g.migrateRows(ctx, failed, func(b *gormBinding) error {
+		return b.save(ctx)
+	}, "(secret <> '' AND secret NOT LIKE 'enc:%') OR (binding <> '' AND binding NOT LIKE 'enc:%') OR (certificate <> '' AND certificate NOT LIKE 'enc:%') OR (mandates <> '' AND mandates NOT LIKE 'enc:%')")
+}
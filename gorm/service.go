@@ -1,7 +1,8 @@
 package gorm
 
 import (
-	"errors"
+	"context"
+	"database/sql"
 
 	controller "github.com/Brickchain/go-controller.v2"
 	"github.com/jinzhu/gorm"
@@ -11,18 +12,42 @@ type gormBindingService struct {
 	db             *gorm.DB
 	postBindFunc   func(controller.Binding)
 	postUnbindFunc func(controller.Binding)
+	kek            []byte
 }
 
-func New(db *gorm.DB) controller.BindingService {
+// New returns a BindingService that stores the bindings in the database using gorm.
+// The table is migrated as by Migrate, without reporting errors.
+func New(db *gorm.DB) controller.ContextBindingService {
+	return NewEncrypted(db, nil)
+}
+
+// NewEncrypted returns a BindingService that stores the bindings in the database using gorm,
+// with the secret, controller-binding, certificate and mandates encrypted using the
+// Key Encryption Key (KEK). Bindings stored in plaintext are encrypted when the service starts,
+// bindings that can not be encrypted, for example when they were encrypted with another KEK, are
+// left as they are and only reported by Migrate. A nil KEK disables encryption.
+func NewEncrypted(db *gorm.DB, kek []byte) controller.ContextBindingService {
 	g := &gormBindingService{
-		db: db,
+		db:  db,
+		kek: kek,
 	}
 
-	db.AutoMigrate(&gormBinding{})
+	// errors can not be returned here, Migrate reports the bindings that could not be converted
+	g.migrate(context.Background())
 
 	return g
 }
 
+// load prepares a binding read from the database for use
+func (g *gormBindingService) load(b *gormBinding) error {
+	b.db = g.db
+	b.postBindFunc = g.postBindFunc
+	b.postUnbindFunc = g.postUnbindFunc
+	b.kek = g.kek
+
+	return b.decrypt()
+}
+
 func (g *gormBindingService) SetPostBind(f func(controller.Binding)) {
 	g.postBindFunc = f
 }
@@ -40,33 +65,168 @@ func (g *gormBindingService) PostUnbind() func(controller.Binding) {
 }
 
 func (g *gormBindingService) New(id string) (controller.Binding, error) {
-	if b, err := g.Get(id); err == nil {
-		return b, errors.New("Binding already exists")
-	}
+	return g.NewContext(context.Background(), id)
+}
 
-	b := newGormBinding(g.db, id, g.postBindFunc, g.postUnbindFunc)
-	err := g.db.Save(b).Error
+func (g *gormBindingService) NewContext(ctx context.Context, id string) (controller.Binding, error) {
+	b, err := newGormBinding(g.db, id, g.postBindFunc, g.postUnbindFunc)
+	if err != nil {
+		return nil, err
+	}
+	b.kek = g.kek
+
+	// the insert fails on the primary key when the ID is taken, also when New races with another New
+	if err = b.create(ctx); err != nil {
+		if existing, gerr := g.GetContext(ctx, id); gerr == nil {
+			return existing, controller.ErrAlreadyExists
+		}
+		return nil, err
+	}
 
-	return b, err
+	return b, nil
 }
 
 func (g *gormBindingService) Get(id string) (controller.Binding, error) {
-	b := &gormBinding{
-		postBindFunc:   g.postBindFunc,
-		postUnbindFunc: g.postUnbindFunc,
-	}
+	return g.GetContext(context.Background(), id)
+}
 
-	err := g.db.Where("id = ?", id).First(&b).Error
+func (g *gormBindingService) GetContext(ctx context.Context, id string) (controller.Binding, error) {
+	b := &gormBinding{}
 
-	b.db = g.db
+	err := scoped(ctx, g.db, func(db *gorm.DB) error {
+		return db.Where("id = ?", id).First(&b).Error
+	})
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, controller.ErrNotFound
+		}
+		return nil, err
+	}
 
-	return b, err
+	if err = g.load(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
 }
 
 func (g *gormBindingService) Delete(id string) error {
-	_, err := g.Get(id)
+	return g.DeleteContext(context.Background(), id)
+}
+
+func (g *gormBindingService) DeleteContext(ctx context.Context, id string) error {
+	_, err := g.GetContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	return scoped(ctx, g.db, func(db *gorm.DB) error {
+		return db.Delete(&gormBinding{}, "id = ?", id).Error
+	})
+}
+
+func (g *gormBindingService) List(opts controller.ListOptions) ([]controller.Binding, string, error) {
+	return g.ListContext(context.Background(), opts)
+}
+
+func (g *gormBindingService) ListContext(ctx context.Context, opts controller.ListOptions) ([]controller.Binding, string, error) {
+	rows := make([]*gormBinding, 0)
+	err := scoped(ctx, g.db, func(db *gorm.DB) error {
+		return listQuery(db, opts).Find(&rows).Error
+	})
 	if err != nil {
+		return nil, "", err
+	}
+
+	cursor := ""
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+		cursor = rows[len(rows)-1].DBid
+	}
+
+	bindings := make([]controller.Binding, 0, len(rows))
+	for _, b := range rows {
+		if err = g.load(b); err != nil {
+			return nil, "", err
+		}
+		bindings = append(bindings, b)
+	}
+
+	return bindings, cursor, nil
+}
+
+// listQuery applies the filters and pagination in ListOptions to the query
+func listQuery(db *gorm.DB, opts controller.ListOptions) *gorm.DB {
+	q := db.Order("id asc")
+
+	if opts.Cursor != "" {
+		q = q.Where("id > ?", opts.Cursor)
+	}
+
+	if opts.Status != "" {
+		q = q.Where("status = ?", opts.Status)
+	}
+
+	if opts.Bound != nil {
+		if *opts.Bound {
+			q = q.Where("binding <> ''")
+		} else {
+			q = q.Where("(binding = '' OR binding IS NULL)")
+		}
+	}
+
+	if opts.Realm != "" {
+		q = q.Where("realm_name = ?", opts.Realm)
+	}
+
+	if opts.RealmThumbprint != "" {
+		q = q.Where("realm_thumbprint = ?", opts.RealmThumbprint)
+	}
+
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit + 1)
+	}
+
+	return q
+}
+
+func (g *gormBindingService) FindByRealm(thumbprint string) ([]controller.Binding, error) {
+	return g.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (g *gormBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]controller.Binding, error) {
+	bindings, _, err := g.ListContext(ctx, controller.ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (g *gormBindingService) FindByRealmName(name string) ([]controller.Binding, error) {
+	return g.FindByRealmNameContext(context.Background(), name)
+}
+
+func (g *gormBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]controller.Binding, error) {
+	bindings, _, err := g.ListContext(ctx, controller.ListOptions{Realm: name})
+	return bindings, err
+}
+
+// scoped runs f against the database. When ctx can be cancelled f is run inside a transaction
+// started with ctx, so the queries are aborted when the context is cancelled or its deadline expires.
+func scoped(ctx context.Context, db *gorm.DB, f func(*gorm.DB) error) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return g.db.Delete(&gormBinding{}, "id = ?", id).Error
+
+	if ctx.Done() == nil {
+		return f(db)
+	}
+
+	tx := db.BeginTx(ctx, &sql.TxOptions{})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
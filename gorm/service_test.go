@@ -0,0 +1,177 @@
+package gorm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-document.v2"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+func Test_gormBindingService_migrateLists(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	defer db.Close()
+
+	db.AutoMigrate(&gormBinding{})
+
+	mandate := `{"payload":"abc","signatures":[{"protected":"def","signature":"ghi"}]}`
+	c, _ := json.Marshal(&document.ControllerBinding{
+		AdminRoles: []string{"admin,ops", "user"},
+		Mandates:   []string{mandate},
+	})
+	legacy := []*gormBinding{
+		{
+			DBid:         "with_binding",
+			DBbinding:    string(c),
+			DBmandates:   mandate,
+			DBadminRoles: "admin,ops,user",
+		},
+		{
+			DBid:         "without_binding",
+			DBmandates:   "abc,def",
+			DBadminRoles: "admin",
+		},
+		{
+			DBid:         "encrypted",
+			DBbinding:    encryptedPrefix + "binding",
+			DBadminRoles: "admin",
+		},
+	}
+	for _, b := range legacy {
+		if err := db.Save(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = Migrate(context.Background(), db, nil)
+	merr, ok := err.(*MigrationError)
+	if !ok || len(merr.Failed) != 1 || merr.Failed["encrypted"] != ErrNoKEK {
+		t.Fatalf("Migrate() error = %v, want the encrypted binding failed with %v", err, ErrNoKEK)
+	}
+
+	svc := New(db)
+
+	tests := []struct {
+		id       string
+		mandates []string
+		roles    []string
+	}{
+		{"with_binding", []string{mandate}, []string{"admin,ops", "user"}},
+		{"without_binding", []string{"abc", "def"}, []string{"admin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			b, err := svc.Get(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := json.Marshal(b.Mandates()); string(got) != b.(*gormBinding).DBmandates || len(b.Mandates()) != len(tt.mandates) || b.Mandates()[0] != tt.mandates[0] {
+				t.Errorf("Mandates() = %v (stored %q), want %v", b.Mandates(), b.(*gormBinding).DBmandates, tt.mandates)
+			}
+			if got, _ := json.Marshal(b.AdminRoles()); string(got) != b.(*gormBinding).DBadminRoles || len(b.AdminRoles()) != len(tt.roles) || b.AdminRoles()[0] != tt.roles[0] {
+				t.Errorf("AdminRoles() = %v (stored %q), want %v", b.AdminRoles(), b.(*gormBinding).DBadminRoles, tt.roles)
+			}
+		})
+	}
+}
+
+func Test_gormBindingService_encryption(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	defer db.Close()
+
+	db.AutoMigrate(&gormBinding{})
+
+	plain := &gormBinding{
+		DBid:          "plain",
+		DBsecret:      "secret",
+		DBbinding:     `{"adminRoles":["admin"]}`,
+		DBcertificate: "certificate",
+		DBmandates:    `["mandate"]`,
+	}
+	if err := db.Save(plain).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	kek := []byte("0123456789abcdef0123456789abcdef")
+
+	// secret encrypted before secrets were hashed
+	legacy, _ := encryptValue("legacy", kek)
+	if err := db.Save(&gormBinding{DBid: "encrypted", DBsecret: legacy}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(context.Background(), db, kek); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	svc := NewEncrypted(db, kek)
+
+	if _, err := svc.New("new"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"plain", "new", "encrypted"} {
+		t.Run(id, func(t *testing.T) {
+			row := &gormBinding{}
+			if err := db.Where("id = ?", id).First(row).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range row.sensitive() {
+				if *v != "" && !strings.HasPrefix(*v, encryptedPrefix) {
+					t.Errorf("column stored in plaintext: %q", *v)
+				}
+			}
+
+			if secret, err := decryptValue(row.DBsecret, kek); err != nil || !controller.IsSecretHash(secret) {
+				t.Errorf("secret not stored as a hash: %q, %v", secret, err)
+			}
+			if !row.DBhashed {
+				t.Error("binding not marked as hashed")
+			}
+
+			if _, err := svc.Get(id); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if b, _ := svc.Get("encrypted"); !b.VerifySecret("legacy") {
+		t.Error("VerifySecret() = false for the migrated encrypted secret")
+	}
+
+	b, _ := svc.Get("plain")
+	if !b.VerifySecret("secret") || b.Certificate() != "certificate" || len(b.Mandates()) != 1 || b.Mandates()[0] != "mandate" {
+		t.Errorf("migrated binding not decrypted: %q %v", b.Certificate(), b.Mandates())
+	}
+
+	if _, err := New(db).Get("plain"); err != ErrNoKEK {
+		t.Errorf("Get() without KEK error = %v, want %v", err, ErrNoKEK)
+	}
+
+	wrong := []byte("fedcba9876543210fedcba9876543210")
+	if _, err := NewEncrypted(db, wrong).Get("plain"); err == nil {
+		t.Error("Get() with wrong KEK succeeded")
+	}
+
+	// a plaintext secret next to a column encrypted with another KEK can not be encrypted
+	encBinding, _ := encryptValue(`{"adminRoles":["admin"]}`, kek)
+	if err := db.Save(&gormBinding{DBid: "mixed", DBsecret: "secret", DBbinding: encBinding}).Error; err != nil {
+		t.Fatal(err)
+	}
+	err = Migrate(context.Background(), db, wrong)
+	// the bindings with hashed secrets are not decrypted again, so only the mixed binding fails
+	if merr, ok := err.(*MigrationError); !ok || len(merr.Failed) != 1 || merr.Failed["mixed"] == nil {
+		t.Errorf("Migrate() with wrong KEK error = %v, want only the mixed binding failed", err)
+	}
+}
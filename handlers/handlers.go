@@ -5,18 +5,40 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	controller "github.com/Brickchain/go-controller.v2"
 	"github.com/Brickchain/go-crypto.v2"
 	"github.com/Brickchain/go-document.v2"
 	httphandler "github.com/Brickchain/go-httphandler.v2"
 	jose "gopkg.in/square/go-jose.v1"
 )
 
-// ControllerDescriptorHandler is a helper for publishing the controller-descriptor on an endpoint
+// publishedDescriptor is the controller-descriptor with the still valid binding keys
+type publishedDescriptor struct {
+	document.ControllerDescriptor
+	Keys []*jose.JsonWebKey `json:"keys,omitempty"`
+}
+
+// ControllerDescriptorHandler is a helper for publishing the controller-descriptor on an endpoint.
+// Key is the current binding key and Keys lists it together with the keys retired within controller.DefaultKeyRetention.
 func ControllerDescriptorHandler(req RequestWithBinding) httphandler.Response {
-	if req.URL().Query().Get("secret") != req.Binding().Secret() {
+	return controllerDescriptor(req, controller.DefaultKeyRetention)
+}
+
+// NewControllerDescriptorHandler returns a ControllerDescriptorHandler that publishes the keys retired within retention.
+// The retention should match the KeyOptions.Retention the binding keys are generated with.
+func NewControllerDescriptorHandler(retention time.Duration) func(RequestWithBinding) httphandler.Response {
+	return func(req RequestWithBinding) httphandler.Response {
+		return controllerDescriptor(req, retention)
+	}
+}
+
+// controllerDescriptor publishes the controller-descriptor with the keys retired within retention
+func controllerDescriptor(req RequestWithBinding, retention time.Duration) httphandler.Response {
+	if !req.Binding().VerifySecret(req.URL().Query().Get("secret")) {
 		return httphandler.NewErrorResponse(http.StatusForbidden, errors.New("Wrong secret"))
 	}
 
@@ -33,13 +55,24 @@ func ControllerDescriptorHandler(req RequestWithBinding) httphandler.Response {
 		descriptor.AdminUI = fmt.Sprintf("%s%sbinding=%s", descriptor.AdminUI, separator, req.Binding().ID())
 	}
 
-	return httphandler.NewJsonResponse(http.StatusOK, descriptor)
+	published := publishedDescriptor{
+		ControllerDescriptor: descriptor,
+	}
+
+	now := time.Now()
+	for _, k := range req.Binding().PublicKeys() {
+		if k.ValidAt(now, retention) {
+			published.Keys = append(published.Keys, k.Key)
+		}
+	}
+
+	return httphandler.NewJsonResponse(http.StatusOK, published)
 }
 
 // BindingCallback handles the controller-binding response
 func BindingCallback(req RequestWithBinding) httphandler.Response {
 
-	if req.URL().Query().Get("secret") != req.Binding().Secret() {
+	if !req.Binding().VerifySecret(req.URL().Query().Get("secret")) {
 		return httphandler.NewErrorResponse(http.StatusForbidden, errors.New("Wrong secret"))
 	}
 
@@ -78,8 +111,13 @@ func BindingCallback(req RequestWithBinding) httphandler.Response {
 		}
 	}
 
-	if err = req.Binding().Bind(payload); err != nil {
-		return httphandler.NewErrorResponse(http.StatusInternalServerError, errors.Wrap(err, "failed to bind"))
+	if b, ok := req.Binding().(controller.ContextBinding); ok {
+		err = b.BindContext(req.Context(), payload)
+	} else {
+		err = req.Binding().Bind(payload)
+	}
+	if err != nil {
+		return bindingErrorResponse(errors.Wrap(err, "failed to bind"))
 	}
 
 	return httphandler.NewEmptyResponse(http.StatusCreated)
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-document.v2"
+	httphandler "github.com/Brickchain/go-httphandler.v2"
+)
+
+// testRequest is a request with a query and body, the methods the handlers do not use are left unimplemented
+type testRequest struct {
+	httphandler.ActionRequest
+	url  *url.URL
+	body []byte
+}
+
+func newTestRequest(query url.Values, body []byte) *testRequest {
+	return &testRequest{
+		url:  &url.URL{Path: "/", RawQuery: query.Encode()},
+		body: body,
+	}
+}
+
+func (r *testRequest) URL() *url.URL                                { return r.url }
+func (r *testRequest) Context() context.Context                     { return context.Background() }
+func (r *testRequest) Body() ([]byte, error)                        { return r.body, nil }
+func (r *testRequest) Mandates() []httphandler.AuthenticatedMandate { return nil }
+func (r *testRequest) Action() *document.Action                     { return &document.Action{} }
+
+func Test_BindingRequests(t *testing.T) {
+	bsvc := controller.NewMemoryBindingService()
+	if _, err := bsvc.New("unbound"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(RequestWithBinding) httphandler.Response { return httphandler.NewEmptyResponse(http.StatusOK) }
+	okAuth := func(AuthenticatedRequestWithBinding) httphandler.Response {
+		return httphandler.NewEmptyResponse(http.StatusOK)
+	}
+	okAction := func(ActionRequestWithBinding) httphandler.Response {
+		return httphandler.NewEmptyResponse(http.StatusOK)
+	}
+
+	tests := []struct {
+		name    string
+		binding string
+		want    int
+	}{
+		{"NotFound", "missing", http.StatusNotFound},
+		{"NotBound", "unbound", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newTestRequest(url.Values{"binding": {tt.binding}}, nil)
+
+			if tt.want == http.StatusNotFound {
+				if got := addBinding(bsvc, ok)(req).StatusCode(); got != tt.want {
+					t.Errorf("addBinding() status = %d, want %d", got, tt.want)
+				}
+			}
+			if got := addAuthenticatedBinding(bsvc, okAuth)(req).StatusCode(); got != tt.want {
+				t.Errorf("addAuthenticatedBinding() status = %d, want %d", got, tt.want)
+			}
+			if got := addActionBinding(bsvc, okAction)(req).StatusCode(); got != tt.want {
+				t.Errorf("addActionBinding() status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_BindingCallback(t *testing.T) {
+	body, _ := json.Marshal(&document.ControllerBinding{
+		ControllerCertificate: "certificate",
+		RealmDescriptor:       &document.RealmDescriptor{Name: "example.com"},
+	})
+
+	tests := []struct {
+		name    string
+		deleted bool
+		want    int
+	}{
+		{"Bound", false, http.StatusCreated},
+		{"Deleted", true, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bsvc := controller.NewMemoryBindingService()
+			b, err := bsvc.New("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.deleted {
+				if err := bsvc.Delete("test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := &standardRequestWithBinding{
+				Request: newTestRequest(url.Values{"secret": {b.Secret()}}, body),
+				binding: b,
+			}
+			if got := BindingCallback(req).StatusCode(); got != tt.want {
+				t.Errorf("BindingCallback() status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
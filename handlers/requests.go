@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	controller "github.com/Brickchain/go-controller.v2"
-	"github.com/Brickchain/go-crypto.v2"
 	httphandler "github.com/Brickchain/go-httphandler.v2"
 	"github.com/pkg/errors"
 )
@@ -68,9 +67,9 @@ func addBinding(bm controller.BindingService, h func(RequestWithBinding) httphan
 			return httphandler.NewErrorResponse(http.StatusBadRequest, errors.New("No binding in request"))
 		}
 
-		binding, err := bm.Get(bindID)
+		binding, err := getBinding(req, bm, bindID)
 		if err != nil {
-			return httphandler.NewErrorResponse(http.StatusInternalServerError, errors.Wrap(err, "could not lookup binding"))
+			return bindingErrorResponse(errors.Wrap(err, "could not lookup binding"))
 		}
 
 		return h(&standardRequestWithBinding{
@@ -88,17 +87,18 @@ func addAuthenticatedBinding(bm controller.BindingService, h func(AuthenticatedR
 			return httphandler.NewErrorResponse(http.StatusBadRequest, errors.New("No binding in request"))
 		}
 
-		binding, err := bm.Get(bindID)
+		binding, err := getBinding(req, bm, bindID)
 		if err != nil {
-			return httphandler.NewErrorResponse(http.StatusInternalServerError, errors.Wrap(err, "could not lookup binding"))
+			return bindingErrorResponse(errors.Wrap(err, "could not lookup binding"))
 		}
 
-		realmTP := crypto.Thumbprint(binding.Realm().PublicKey)
+		if binding.Realm() == nil {
+			return bindingErrorResponse(controller.ErrNotBound)
+		}
 
 		success := false
 		for _, mandate := range req.Mandates() {
-			signerTP := crypto.Thumbprint(mandate.Signer)
-			if realmTP == signerTP && hasRole(binding.AdminRoles(), mandate.Mandate.Role) {
+			if controller.IsAdminMandate(binding, mandate.Signer, mandate.Mandate) {
 				success = true
 			}
 		}
@@ -130,17 +130,18 @@ func addActionBinding(bm controller.BindingService, h func(ActionRequestWithBind
 			}
 		}
 
-		binding, err := bm.Get(bindID)
+		binding, err := getBinding(req, bm, bindID)
 		if err != nil {
-			return httphandler.NewErrorResponse(http.StatusInternalServerError, errors.Wrap(err, "could not lookup binding"))
+			return bindingErrorResponse(errors.Wrap(err, "could not lookup binding"))
 		}
 
-		realmTP := crypto.Thumbprint(binding.Realm().PublicKey)
+		if binding.Realm() == nil {
+			return bindingErrorResponse(controller.ErrNotBound)
+		}
 
 		success := false
 		for _, mandate := range req.Mandates() {
-			signerTP := crypto.Thumbprint(mandate.Signer)
-			if realmTP == signerTP && hasRole(binding.AdminRoles(), mandate.Mandate.Role) {
+			if controller.IsAdminMandate(binding, mandate.Signer, mandate.Mandate) {
 				success = true
 			}
 		}
@@ -156,12 +157,23 @@ func addActionBinding(bm controller.BindingService, h func(ActionRequestWithBind
 	}
 }
 
-func hasRole(roleList []string, role string) bool {
-	for _, r := range roleList {
-		if r == role {
-			return true
-		}
+// getBinding looks up the binding, passing on the request context if the BindingService supports it
+func getBinding(req httphandler.Request, bm controller.BindingService, id string) (controller.Binding, error) {
+	if cbm, ok := bm.(controller.ContextBindingService); ok {
+		return cbm.GetContext(req.Context(), id)
+	}
+
+	return bm.Get(id)
+}
+
+// bindingErrorResponse maps the controller errors to the matching HTTP status code
+func bindingErrorResponse(err error) httphandler.Response {
+	switch {
+	case errors.Is(err, controller.ErrNotFound):
+		return httphandler.NewErrorResponse(http.StatusNotFound, err)
+	case errors.Is(err, controller.ErrAlreadyExists), errors.Is(err, controller.ErrNotBound):
+		return httphandler.NewErrorResponse(http.StatusConflict, err)
 	}
 
-	return false
+	return httphandler.NewErrorResponse(http.StatusInternalServerError, err)
 }
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes used by the JWS algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	gocrypto "github.com/Brickchain/go-crypto.v2"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+var jwsHashes = map[string]crypto.Hash{
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+}
+
+// SignJWS returns a JWS in JSON serialization of the payload, signed by sign with the key pk.
+// The protected header holds alg, the public key as jwk, its kid and the extra headers. sign is given
+// the JWS signing input and returns the signature in the format of the algorithm, so a KeyStore backed
+// by an external key manager only has to provide the raw signature operation.
+func SignJWS(pk *jose.JsonWebKey, alg string, payload []byte, headers map[string]interface{}, sign func(input []byte) ([]byte, error)) (string, error) {
+	if _, ok := jwsHashes[alg]; !ok {
+		return "", errors.Wrap(ErrUnsupportedKeyAlgorithm, alg)
+	}
+
+	kid := pk.KeyID
+	if kid == "" {
+		kid = gocrypto.Thumbprint(pk)
+	}
+
+	header := make(map[string]interface{}, len(headers)+3)
+	for k, v := range headers {
+		header[k] = v
+	}
+	header["alg"] = alg
+	header["jwk"] = pk
+	header["kid"] = kid
+
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString(protected) + "." + enc.EncodeToString(payload)
+
+	sig, err := sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.SplitN(input, ".", 2)
+	out, err := json.Marshal(map[string]string{
+		"protected": parts[0],
+		"payload":   parts[1],
+		"signature": enc.EncodeToString(sig),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// signWithKey returns the JWS signature of the input made with the private key for alg
+func signWithKey(key *jose.JsonWebKey, alg string, input []byte) ([]byte, error) {
+	hash, ok := jwsHashes[alg]
+	if !ok {
+		return nil, errors.Wrap(ErrUnsupportedKeyAlgorithm, alg)
+	}
+
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch k := key.Key.(type) {
+	case *ecdsa.PrivateKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[size-len(rb):size], rb)
+		copy(sig[2*size-len(sb):], sb)
+
+		return sig, nil
+
+	case *rsa.PrivateKey:
+		switch {
+		case strings.HasPrefix(alg, "RS"):
+			return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		case strings.HasPrefix(alg, "PS"):
+			return rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	}
+
+	return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "%s can not be used with the key", alg)
+}
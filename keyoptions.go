@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"time"
+
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// MinRSAKeySize is the smallest RSA key size accepted by KeyOptions
+const MinRSAKeySize = 2048
+
+// KeyOptions selects the kind of key GenerateKeyWithOptions creates.
+// The zero value generates the default go-crypto key.
+//
+// Ed25519 keys are not available yet: go-jose.v1, which go-crypto and go-document are built on,
+// has no EdDSA support, so they need to move to a JOSE library that has it first. Until then
+// Algorithm EdDSA and Curve Ed25519 fail with ErrUnsupportedKeyAlgorithm.
+type KeyOptions struct {
+	// Algorithm is the JWS algorithm the key is used with, e.g. ES256, ES384, ES512, RS256 or PS256.
+	// When empty it is derived from Curve, or from KeySize for RSA keys.
+	Algorithm string
+
+	// Curve of an elliptic curve key, P-256, P-384 or P-521.
+	Curve string
+
+	// KeySize in bits of an RSA key, defaults to MinRSAKeySize.
+	KeySize int
+
+	// Retention is how long retired keys are kept, defaults to DefaultKeyRetention.
+	// Keys retired longer ago are removed, with their private keys, when a new key is generated.
+	Retention time.Duration
+}
+
+// retention returns the Retention or DefaultKeyRetention
+func (o KeyOptions) retention() time.Duration {
+	if o.Retention > 0 {
+		return o.Retention
+	}
+
+	return DefaultKeyRetention
+}
+
+var curveAlgorithms = map[string]string{
+	"P-256": "ES256",
+	"P-384": "ES384",
+	"P-521": "ES512",
+}
+
+var algorithmCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+var rsaAlgorithms = map[string]bool{
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+	"PS256": true,
+	"PS384": true,
+	"PS512": true,
+}
+
+// NewKeyPair generates a private key and its public key according to opts. The JWS algorithm is
+// recorded in the Algorithm field of both keys, so callers of PrivateKey know how to sign.
+func NewKeyPair(opts KeyOptions) (key, pk *jose.JsonWebKey, err error) {
+	key, err = newPrivateKey(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pk, err = crypto.NewPublicKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk.Algorithm = key.Algorithm
+	pk.KeyID = key.KeyID
+
+	return key, pk, nil
+}
+
+func newPrivateKey(opts KeyOptions) (*jose.JsonWebKey, error) {
+	if opts.Algorithm == "EdDSA" || opts.Curve == "Ed25519" {
+		return nil, errors.Wrap(ErrUnsupportedKeyAlgorithm, "EdDSA keys are not supported until go-jose.v1 is replaced")
+	}
+
+	alg := opts.Algorithm
+	if alg == "" && opts.Curve != "" {
+		alg = curveAlgorithms[opts.Curve]
+		if alg == "" {
+			return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "curve %s", opts.Curve)
+		}
+	}
+	if alg == "" && opts.KeySize != 0 {
+		alg = "RS256"
+	}
+
+	var k interface{}
+	switch {
+	case alg == "":
+		key, err := crypto.NewKey()
+		if err != nil {
+			return nil, err
+		}
+		if key.Algorithm == "" {
+			key.Algorithm = defaultAlgorithm(key)
+		}
+		return key, nil
+
+	case algorithmCurves[alg] != nil:
+		curve := algorithmCurves[alg]
+		if opts.Curve != "" && opts.Curve != curve.Params().Name {
+			return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "curve %s can not be used with %s", opts.Curve, alg)
+		}
+		if opts.KeySize != 0 {
+			return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "key size can not be used with %s", alg)
+		}
+
+		ec, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		k = ec
+
+	case rsaAlgorithms[alg]:
+		if opts.Curve != "" {
+			return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "curve %s can not be used with %s", opts.Curve, alg)
+		}
+
+		size := opts.KeySize
+		if size == 0 {
+			size = MinRSAKeySize
+		}
+		if size < MinRSAKeySize {
+			return nil, errors.Wrapf(ErrUnsupportedKeyAlgorithm, "RSA key size %d is smaller than %d", size, MinRSAKeySize)
+		}
+
+		r, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			return nil, err
+		}
+		k = r
+
+	default:
+		return nil, errors.Wrap(ErrUnsupportedKeyAlgorithm, alg)
+	}
+
+	key := &jose.JsonWebKey{
+		Key:       k,
+		Algorithm: alg,
+	}
+	key.KeyID = crypto.Thumbprint(key)
+
+	return key, nil
+}
+
+// defaultAlgorithm returns the JWS algorithm for a key generated by go-crypto
+func defaultAlgorithm(key *jose.JsonWebKey) string {
+	switch k := key.Key.(type) {
+	case *ecdsa.PrivateKey:
+		return curveAlgorithms[k.Curve.Params().Name]
+	case *rsa.PrivateKey:
+		return "RS256"
+	}
+
+	return ""
+}
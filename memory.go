@@ -0,0 +1,536 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// memoryRecord holds the stored state of a binding
+type memoryRecord struct {
+	id                    string
+	secretHash            string
+	previousSecretHash    string
+	previousSecretExpires time.Time
+	publicKey             *jose.JsonWebKey
+	keyCreated            time.Time
+	retiredKeys           []BindingKey
+	descriptor            document.ControllerDescriptor
+	binding               *document.ControllerBinding
+	status                string
+	bindEndpoint          string
+}
+
+// clone returns a copy of the record that does not share any slices or pointers
+// to mutable documents with the original
+func (r memoryRecord) clone() memoryRecord {
+	r.publicKey = copyKey(r.publicKey)
+	r.descriptor.Key = copyKey(r.descriptor.Key)
+
+	if r.retiredKeys != nil {
+		retired := make([]BindingKey, 0, len(r.retiredKeys))
+		for _, k := range r.retiredKeys {
+			k.Key = copyKey(k.Key)
+			retired = append(retired, k)
+		}
+		r.retiredKeys = retired
+	}
+
+	if r.binding != nil {
+		c := *r.binding
+		c.Mandates = copyStrings(c.Mandates)
+		c.AdminRoles = copyStrings(c.AdminRoles)
+		if c.RealmDescriptor != nil {
+			realm := *c.RealmDescriptor
+			realm.PublicKey = copyKey(realm.PublicKey)
+			c.RealmDescriptor = &realm
+		}
+		r.binding = &c
+	}
+
+	return r
+}
+
+// copyKey returns a copy of the JWK, the key material itself is never modified and is shared
+func copyKey(k *jose.JsonWebKey) *jose.JsonWebKey {
+	if k == nil {
+		return nil
+	}
+
+	c := *k
+	c.Certificates = append([]*x509.Certificate(nil), k.Certificates...)
+
+	return &c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// memoryBinding is a handle to a binding in the memoryBindingService.
+// Reads are served from a copy of the record taken on Get, writes are applied to the
+// record in the service and the copy is refreshed from it.
+type memoryBinding struct {
+	svc    *memoryBindingService
+	mu     sync.RWMutex
+	rec    memoryRecord
+	secret string
+}
+
+// update applies f to the stored record and refreshes this handle from it
+func (m *memoryBinding) update(f func(*memoryRecord)) error {
+	m.svc.mu.Lock()
+	defer m.svc.mu.Unlock()
+
+	rec, ok := m.svc.bindings[m.ID()]
+	if !ok {
+		return ErrNotFound
+	}
+	f(&rec)
+	m.svc.bindings[rec.id] = rec
+
+	m.mu.Lock()
+	m.rec = rec.clone()
+	m.mu.Unlock()
+
+	return nil
+}
+
+// read returns a copy of the record held by this handle
+func (m *memoryBinding) read() memoryRecord {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.rec.clone()
+}
+
+func (m *memoryBinding) ID() string {
+	return m.read().id
+}
+
+func (m *memoryBinding) Secret() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.secret
+}
+
+func (m *memoryBinding) VerifySecret(secret string) bool {
+	rec := m.read()
+	return VerifyRotatedSecret(rec.secretHash, rec.previousSecretHash, rec.previousSecretExpires, secret)
+}
+
+func (m *memoryBinding) RotateSecret(grace time.Duration) (string, error) {
+	return m.RotateSecretContext(context.Background(), grace)
+}
+
+func (m *memoryBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	secret, hash, err := NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(grace)
+	err = m.update(func(r *memoryRecord) {
+		r.previousSecretHash = r.secretHash
+		r.previousSecretExpires = expires
+		r.secretHash = hash
+	})
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	m.secret = secret
+	m.mu.Unlock()
+
+	return secret, nil
+}
+
+func (m *memoryBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return m.GenerateKeyContext(context.Background(), svc, kek)
+}
+
+func (m *memoryBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	return m.GenerateKeyWithOptionsContext(ctx, svc, kek, KeyOptions{})
+}
+
+func (m *memoryBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts KeyOptions) error {
+	return m.GenerateKeyWithOptionsContext(context.Background(), svc, kek, opts)
+}
+
+func (m *memoryBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts KeyOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rec := m.read()
+	current := BindingKey{Key: rec.publicKey, Created: rec.keyCreated}
+
+	return ReplaceKey(svc, rec.id, kek, opts, current, rec.retiredKeys, func(k BindingKey, retired []BindingKey) error {
+		return m.update(func(r *memoryRecord) {
+			r.publicKey = k.Key
+			r.keyCreated = k.Created
+			r.retiredKeys = retired
+		})
+	})
+}
+
+func (m *memoryBinding) PublicKey() *jose.JsonWebKey {
+	return m.read().publicKey
+}
+
+func (m *memoryBinding) PublicKeys() []BindingKey {
+	rec := m.read()
+	return KeySet(BindingKey{Key: rec.publicKey, Created: rec.keyCreated}, rec.retiredKeys)
+}
+
+func (m *memoryBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.JsonWebKey, error) {
+	skey, err := svc.Get(m.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	return skey.Decrypt(kek)
+}
+
+func (m *memoryBinding) Signer(ks KeyStore) (Signer, error) {
+	return ks.Signer(m)
+}
+
+func (m *memoryBinding) Descriptor() document.ControllerDescriptor {
+	return m.read().descriptor
+}
+
+func (m *memoryBinding) SetDescriptor(desc document.ControllerDescriptor) error {
+	return m.SetDescriptorContext(context.Background(), desc)
+}
+
+func (m *memoryBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.update(func(r *memoryRecord) {
+		r.descriptor = desc
+	})
+}
+
+func (m *memoryBinding) Bind(c *document.ControllerBinding) error {
+	return m.BindContext(context.Background(), c)
+}
+
+func (m *memoryBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	binding := memoryRecord{binding: c}.clone().binding
+	if err := m.update(func(r *memoryRecord) {
+		r.binding = binding
+	}); err != nil {
+		return err
+	}
+
+	if f := m.svc.postBind(); f != nil {
+		f(m)
+	}
+
+	return nil
+}
+
+func (m *memoryBinding) Unbind() error {
+	return m.UnbindContext(context.Background())
+}
+
+func (m *memoryBinding) UnbindContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.read().binding == nil {
+		return ErrNotBound
+	}
+
+	if err := m.update(func(r *memoryRecord) {
+		r.binding = nil
+	}); err != nil {
+		return err
+	}
+
+	if f := m.svc.postUnbind(); f != nil {
+		f(m)
+	}
+
+	return nil
+}
+
+func (m *memoryBinding) Certificate() string {
+	rec := m.read()
+	if rec.binding == nil {
+		return ""
+	}
+
+	return rec.binding.ControllerCertificate
+}
+
+func (m *memoryBinding) Mandates() []string {
+	rec := m.read()
+	if rec.binding == nil {
+		return nil
+	}
+
+	return rec.binding.Mandates
+}
+
+func (m *memoryBinding) AdminRoles() []string {
+	rec := m.read()
+	if rec.binding == nil {
+		return []string{}
+	}
+
+	return rec.binding.AdminRoles
+}
+
+func (m *memoryBinding) Realm() *document.RealmDescriptor {
+	rec := m.read()
+	if rec.binding == nil {
+		return nil
+	}
+
+	return rec.binding.RealmDescriptor
+}
+
+func (m *memoryBinding) ControllerBinding() *document.ControllerBinding {
+	return m.read().binding
+}
+
+func (m *memoryBinding) Status() string {
+	return m.read().status
+}
+
+func (m *memoryBinding) SetStatus(v string) error {
+	return m.SetStatusContext(context.Background(), v)
+}
+
+func (m *memoryBinding) SetStatusContext(ctx context.Context, v string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.update(func(r *memoryRecord) {
+		r.status = v
+	})
+}
+
+func (m *memoryBinding) BindEndpoint() string {
+	return m.read().bindEndpoint
+}
+
+func (m *memoryBinding) SetBindEndpoint(v string) error {
+	return m.SetBindEndpointContext(context.Background(), v)
+}
+
+func (m *memoryBinding) SetBindEndpointContext(ctx context.Context, v string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.update(func(r *memoryRecord) {
+		r.bindEndpoint = v
+	})
+}
+
+type memoryBindingService struct {
+	mu             sync.RWMutex
+	bindings       map[string]memoryRecord
+	postBindFunc   func(Binding)
+	postUnbindFunc func(Binding)
+}
+
+// NewMemoryBindingService returns a BindingService that keeps the bindings in memory.
+// It is safe for concurrent use, but the bindings are lost when the process exits.
+func NewMemoryBindingService() ContextBindingService {
+	return &memoryBindingService{
+		bindings: make(map[string]memoryRecord),
+	}
+}
+
+// handle returns a new memoryBinding for a copy of rec
+func (s *memoryBindingService) handle(rec memoryRecord) *memoryBinding {
+	return &memoryBinding{
+		svc: s,
+		rec: rec.clone(),
+	}
+}
+
+func (s *memoryBindingService) New(id string) (Binding, error) {
+	return s.NewContext(context.Background(), id)
+}
+
+func (s *memoryBindingService) NewContext(ctx context.Context, id string) (Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.bindings[id]; ok {
+		return s.handle(rec), ErrAlreadyExists
+	}
+
+	secret, hash, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	rec := memoryRecord{
+		id:         id,
+		secretHash: hash,
+	}
+	s.bindings[id] = rec
+
+	b := s.handle(rec)
+	b.secret = secret
+
+	return b, nil
+}
+
+func (s *memoryBindingService) Get(id string) (Binding, error) {
+	return s.GetContext(context.Background(), id)
+}
+
+func (s *memoryBindingService) GetContext(ctx context.Context, id string) (Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.bindings[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return s.handle(rec), nil
+}
+
+func (s *memoryBindingService) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *memoryBindingService) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.bindings[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.bindings, id)
+
+	return nil
+}
+
+func (s *memoryBindingService) List(opts ListOptions) ([]Binding, string, error) {
+	return s.ListContext(context.Background(), opts)
+}
+
+func (s *memoryBindingService) ListContext(ctx context.Context, opts ListOptions) ([]Binding, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	handles := make([]*memoryBinding, 0, len(s.bindings))
+	for id, rec := range s.bindings {
+		if id > opts.Cursor {
+			handles = append(handles, s.handle(rec))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(handles, func(i, j int) bool {
+		return handles[i].rec.id < handles[j].rec.id
+	})
+
+	bindings := make([]Binding, 0)
+	for _, b := range handles {
+		if !opts.Match(b) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(bindings) == opts.Limit {
+			return bindings, bindings[len(bindings)-1].ID(), nil
+		}
+
+		bindings = append(bindings, b)
+	}
+
+	return bindings, "", nil
+}
+
+func (s *memoryBindingService) FindByRealm(thumbprint string) ([]Binding, error) {
+	return s.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (s *memoryBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]Binding, error) {
+	bindings, _, err := s.ListContext(ctx, ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (s *memoryBindingService) FindByRealmName(name string) ([]Binding, error) {
+	return s.FindByRealmNameContext(context.Background(), name)
+}
+
+func (s *memoryBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]Binding, error) {
+	bindings, _, err := s.ListContext(ctx, ListOptions{Realm: name})
+	return bindings, err
+}
+
+func (s *memoryBindingService) SetPostBind(f func(Binding)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.postBindFunc = f
+}
+
+func (s *memoryBindingService) postBind() func(Binding) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.postBindFunc
+}
+
+func (s *memoryBindingService) SetPostUnbind(f func(Binding)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.postUnbindFunc = f
+}
+
+func (s *memoryBindingService) postUnbind() func(Binding) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.postUnbindFunc
+}
@@ -0,0 +1,107 @@
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+)
+
+func Test_MemoryBindingService_CopyOnRead(t *testing.T) {
+	svc := controller.NewMemoryBindingService()
+	b, err := svc.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	c := &document.ControllerBinding{
+		Mandates:        []string{"abc"},
+		RealmDescriptor: &document.RealmDescriptor{Name: "example.com", PublicKey: realmPK},
+	}
+	if err := b.Bind(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Mandates[0] = "changed"
+	c.RealmDescriptor.Name = "changed"
+	b.Mandates()[0] = "changed"
+	b.Realm().Name = "changed"
+	b.Realm().PublicKey.KeyID = "changed"
+	c.RealmDescriptor.PublicKey.Algorithm = "changed"
+	b.ControllerBinding().Mandates[0] = "changed"
+
+	for _, b := range []controller.Binding{b, mustGet(t, svc, "test")} {
+		if b.Mandates()[0] != "abc" {
+			t.Errorf("Mandates = %v, want [abc]", b.Mandates())
+		}
+		if b.Realm().Name != "example.com" {
+			t.Errorf("Realm().Name = %v, want example.com", b.Realm().Name)
+		}
+		if pk := b.Realm().PublicKey; pk.KeyID != realmKey.KeyID || pk.Algorithm != realmKey.Algorithm {
+			t.Errorf("Realm().PublicKey = %v/%v, want %v/%v", pk.KeyID, pk.Algorithm, realmKey.KeyID, realmKey.Algorithm)
+		}
+	}
+}
+
+func Test_MemoryBindingService_SharedWrites(t *testing.T) {
+	svc := controller.NewMemoryBindingService()
+	a, _ := svc.New("test")
+	b := mustGet(t, svc, "test")
+
+	if err := a.SetStatus("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetBindEndpoint("https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := mustGet(t, svc, "test")
+	if got.Status() != "ready" || got.BindEndpoint() != "https://example.com" {
+		t.Errorf("Status = %v, BindEndpoint = %v", got.Status(), got.BindEndpoint())
+	}
+
+	if err := svc.Delete("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetStatus("gone"); err != controller.ErrNotFound {
+		t.Errorf("SetStatus() on deleted binding error = %v, want %v", err, controller.ErrNotFound)
+	}
+	if a.Status() != "ready" {
+		t.Errorf("Status() after failed SetStatus = %v, want ready", a.Status())
+	}
+}
+
+func Test_MemoryBindingService_StaleHandle(t *testing.T) {
+	svc := controller.NewMemoryBindingService()
+	svc.New("test")
+	a := mustGet(t, svc, "test")
+	b := mustGet(t, svc, "test")
+
+	first, err := a.RotateSecret(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.RotateSecret(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, h := range map[string]controller.Binding{"stale": b, "stored": mustGet(t, svc, "test")} {
+		if !h.VerifySecret(first) || !h.VerifySecret(second) {
+			t.Errorf("%s VerifySecret() does not accept both rotated secrets", name)
+		}
+	}
+}
+
+func mustGet(t *testing.T, svc controller.BindingService, id string) controller.Binding {
+	t.Helper()
+	b, err := svc.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
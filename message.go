@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// CertificateHeader is the protected JWS header SignDocument puts the controller certificate in
+const CertificateHeader = "certificate"
+
+// SignDocument marshals the document and signs it with the binding key, returning the JWS in JSON serialization.
+// The protected header holds the binding public key, its kid and the controller certificate from Certificate()
+// in the CertificateHeader. The document itself is signed as is.
+func SignDocument(signer Signer, b Binding, doc interface{}) (string, error) {
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+
+	var headers map[string]interface{}
+	if cert := b.Certificate(); cert != "" {
+		headers = map[string]interface{}{CertificateHeader: cert}
+	}
+
+	return signer.Sign(payload, headers)
+}
+
+// EncryptToRealm encrypts the payload to the public key of the realm the binding is bound to,
+// returning the JWE in JSON serialization.
+func EncryptToRealm(b Binding, payload []byte) (string, error) {
+	realm := b.Realm()
+	if realm == nil || realm.PublicKey == nil {
+		return "", ErrNotBound
+	}
+
+	var alg jose.KeyAlgorithm
+	switch realm.PublicKey.Key.(type) {
+	case *ecdsa.PublicKey:
+		alg = jose.ECDH_ES_A256KW
+	case *rsa.PublicKey:
+		alg = jose.RSA_OAEP_256
+	default:
+		return "", ErrUnsupportedKeyAlgorithm
+	}
+
+	enc, err := jose.NewEncrypter(alg, jose.A256GCM, realm.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	jwe, err := enc.Encrypt(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return jwe.FullSerialize(), nil
+}
+
+// VerifyFromRealm verifies that the JWS was signed by the realm the binding is bound to and returns the payload.
+// The JWS must have exactly one signature. Returns ErrNotSignedByRealm if it has more or was signed by any other key.
+func VerifyFromRealm(b Binding, data []byte) ([]byte, error) {
+	realm := b.Realm()
+	if realm == nil || realm.PublicKey == nil {
+		return nil, ErrNotBound
+	}
+
+	jws, err := crypto.UnmarshalSignature(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jws.Signatures) != 1 {
+		return nil, ErrNotSignedByRealm
+	}
+
+	if key := jws.Signatures[0].Header.JsonWebKey; key != nil && crypto.Thumbprint(key) != crypto.Thumbprint(realm.PublicKey) {
+		return nil, ErrNotSignedByRealm
+	}
+
+	payload, err := jws.Verify(realm.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrNotSignedByRealm, err.Error())
+	}
+
+	return payload, nil
+}
+
+// DecryptFromRealm decrypts a JWE sent to the binding, in compact or JSON serialization.
+func DecryptFromRealm(signer Signer, data []byte) ([]byte, error) {
+	jwe, err := jose.ParseEncrypted(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Decrypt(jwe)
+}
@@ -0,0 +1,168 @@
+package controller_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+func signWith(t *testing.T, key *jose.JsonWebKey, payload string) []byte {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.ES256, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(jws.FullSerialize())
+}
+
+// withSignatures joins JWSs of the same payload in flattened JSON serialization into one with all the signatures
+func withSignatures(t *testing.T, jwss ...[]byte) []byte {
+	t.Helper()
+
+	type signature struct {
+		Protected string          `json:"protected,omitempty"`
+		Header    json.RawMessage `json:"header,omitempty"`
+		Signature string          `json:"signature"`
+	}
+	var out struct {
+		Payload    string      `json:"payload"`
+		Signatures []signature `json:"signatures"`
+	}
+	for _, j := range jwss {
+		var flat struct {
+			signature
+			Payload string `json:"payload"`
+		}
+		if err := json.Unmarshal(j, &flat); err != nil {
+			t.Fatal(err)
+		}
+		out.Payload = flat.Payload
+		out.Signatures = append(out.Signatures, flat.signature)
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func Test_Binding_Messages(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	otherKey, _ := crypto.NewKey()
+
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			bsvc := svc.Create(t)
+			ksvc := keys.NewMockStoredKeyService()
+			kek := crypto.NewSymmetricKey(jose.A256KW)
+
+			b, err := bsvc.New("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := b.GenerateKey(ksvc, kek); err != nil {
+				t.Fatal(err)
+			}
+			b, _ = bsvc.Get("test")
+
+			if _, err := controller.EncryptToRealm(b, []byte("secret")); err != controller.ErrNotBound {
+				t.Errorf("EncryptToRealm() on unbound binding error = %v, want %v", err, controller.ErrNotBound)
+			}
+			if _, err := controller.VerifyFromRealm(b, signWith(t, realmKey, "hello")); err != controller.ErrNotBound {
+				t.Errorf("VerifyFromRealm() on unbound binding error = %v, want %v", err, controller.ErrNotBound)
+			}
+
+			err = b.Bind(&document.ControllerBinding{
+				ControllerCertificate: "certificate",
+				RealmDescriptor:       &document.RealmDescriptor{Name: "example.com", PublicKey: realmPK},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ = bsvc.Get("test")
+
+			signer, err := b.Signer(controller.NewStoredKeyStore(ksvc, kek))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run("SignDocument", func(t *testing.T) {
+				docs := []struct {
+					name string
+					doc  interface{}
+				}{
+					{"Descriptor", &document.ControllerDescriptor{Label: "test"}},
+					{"OwnCertificate", &document.ControllerDescriptor{Base: document.Base{Certificate: "own"}}},
+					{"NotAnObject", "text"},
+				}
+				for _, d := range docs {
+					t.Run(d.name, func(t *testing.T) {
+						s, err := controller.SignDocument(signer, b, d.doc)
+						if err != nil {
+							t.Fatalf("SignDocument() error = %v", err)
+						}
+						payload, header := verifySigned(t, b.PublicKey(), s)
+						if header[controller.CertificateHeader] != "certificate" {
+							t.Errorf("SignDocument() certificate header = %v, want certificate", header[controller.CertificateHeader])
+						}
+						want, _ := json.Marshal(d.doc)
+						if payload != string(want) {
+							t.Errorf("SignDocument() payload = %s, want %s", payload, want)
+						}
+					})
+				}
+			})
+
+			t.Run("EncryptToRealm", func(t *testing.T) {
+				s, err := controller.EncryptToRealm(b, []byte("secret"))
+				if err != nil {
+					t.Fatalf("EncryptToRealm() error = %v", err)
+				}
+				jwe, err := jose.ParseEncrypted(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := jwe.Decrypt(realmKey.Key); err != nil || string(got) != "secret" {
+					t.Errorf("EncryptToRealm() did not decrypt with the realm key: %s, %v", got, err)
+				}
+			})
+
+			t.Run("VerifyFromRealm", func(t *testing.T) {
+				got, err := controller.VerifyFromRealm(b, signWith(t, realmKey, "hello"))
+				if err != nil || string(got) != "hello" {
+					t.Errorf("VerifyFromRealm() = %s, %v, want hello", got, err)
+				}
+				if _, err := controller.VerifyFromRealm(b, signWith(t, otherKey, "hello")); !errors.Is(err, controller.ErrNotSignedByRealm) {
+					t.Errorf("VerifyFromRealm() signed by other key error = %v, want %v", err, controller.ErrNotSignedByRealm)
+				}
+				both := withSignatures(t, signWith(t, realmKey, "hello"), signWith(t, otherKey, "hello"))
+				if _, err := controller.VerifyFromRealm(b, both); !errors.Is(err, controller.ErrNotSignedByRealm) {
+					t.Errorf("VerifyFromRealm() with two signatures error = %v, want %v", err, controller.ErrNotSignedByRealm)
+				}
+			})
+
+			t.Run("DecryptFromRealm", func(t *testing.T) {
+				jwe := encryptTo(t, b.PublicKey(), "from realm")
+				got, err := controller.DecryptFromRealm(signer, []byte(jwe.FullSerialize()))
+				if err != nil || string(got) != "from realm" {
+					t.Errorf("DecryptFromRealm() = %s, %v, want from realm", got, err)
+				}
+			})
+		})
+	}
+}
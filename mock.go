@@ -1,7 +1,9 @@
 package controller
 
 import (
-	"errors"
+	"context"
+	"sort"
+	"time"
 
 	"github.com/Brickchain/go-crypto.v2"
 	"github.com/Brickchain/go-document.v2"
@@ -9,10 +11,16 @@ import (
 	jose "gopkg.in/square/go-jose.v1"
 )
 
+// mockBinding is a binding in the mockBindingService. The service hands out the same mockBinding to
+// every caller and it is not safe for concurrent use, see NewMemoryBindingService for that.
 type mockBinding struct {
 	id             string
 	secret         string
+	prevSecret     string
+	prevExpires    time.Time
 	publicKey      *jose.JsonWebKey
+	keyCreated     time.Time
+	retiredKeys    []BindingKey
 	descriptor     document.ControllerDescriptor
 	binding        *document.ControllerBinding
 	status         string
@@ -39,38 +47,46 @@ func (m *mockBinding) Secret() string {
 	return m.secret
 }
 
-func (m *mockBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
-	key, err := crypto.NewKey()
-	if err != nil {
-		return err
-	}
+func (m *mockBinding) VerifySecret(secret string) bool {
+	return VerifyRotatedSecret(m.secret, m.prevSecret, m.prevExpires, secret)
+}
 
-	pk, err := crypto.NewPublicKey(key)
+func (m *mockBinding) RotateSecret(grace time.Duration) (string, error) {
+	secret, err := crypto.GenerateRandomString(42)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	skey := &keys.StoredKey{
-		ID: m.id,
-	}
+	m.prevSecret = m.secret
+	m.prevExpires = time.Now().Add(grace)
+	m.secret = secret
 
-	if err = skey.Encrypt(key, kek); err != nil {
-		return err
-	}
+	return secret, nil
+}
 
-	if err = svc.Save(skey); err != nil {
-		return err
-	}
+func (m *mockBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return m.GenerateKeyWithOptions(svc, kek, KeyOptions{})
+}
 
-	m.publicKey = pk
+func (m *mockBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts KeyOptions) error {
+	current := BindingKey{Key: m.publicKey, Created: m.keyCreated}
 
-	return nil
+	return ReplaceKey(svc, m.id, kek, opts, current, m.retiredKeys, func(k BindingKey, retired []BindingKey) error {
+		m.publicKey = k.Key
+		m.keyCreated = k.Created
+		m.retiredKeys = retired
+		return nil
+	})
 }
 
 func (m *mockBinding) PublicKey() *jose.JsonWebKey {
 	return m.publicKey
 }
 
+func (m *mockBinding) PublicKeys() []BindingKey {
+	return KeySet(BindingKey{Key: m.publicKey, Created: m.keyCreated}, m.retiredKeys)
+}
+
 func (m *mockBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.JsonWebKey, error) {
 	skey, err := svc.Get(m.id)
 	if err != nil {
@@ -80,6 +96,10 @@ func (m *mockBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.J
 	return skey.Decrypt(kek)
 }
 
+func (m *mockBinding) Signer(ks KeyStore) (Signer, error) {
+	return ks.Signer(m)
+}
+
 func (m *mockBinding) Descriptor() document.ControllerDescriptor {
 	return m.descriptor
 }
@@ -101,6 +121,10 @@ func (m *mockBinding) Bind(c *document.ControllerBinding) error {
 }
 
 func (m *mockBinding) Unbind() error {
+	if m.binding == nil {
+		return ErrNotBound
+	}
+
 	m.binding = nil
 
 	if m.postUnbindFunc != nil {
@@ -164,14 +188,79 @@ func (m *mockBinding) SetBindEndpoint(v string) error {
 	return nil
 }
 
+func (m *mockBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.GenerateKey(svc, kek)
+}
+
+func (m *mockBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts KeyOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.GenerateKeyWithOptions(svc, kek, opts)
+}
+
+func (m *mockBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return m.RotateSecret(grace)
+}
+
+func (m *mockBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.SetDescriptor(desc)
+}
+
+func (m *mockBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.Bind(c)
+}
+
+func (m *mockBinding) UnbindContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.Unbind()
+}
+
+func (m *mockBinding) SetStatusContext(ctx context.Context, v string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.SetStatus(v)
+}
+
+func (m *mockBinding) SetBindEndpointContext(ctx context.Context, v string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.SetBindEndpoint(v)
+}
+
 type mockBindingService struct {
 	bindings       map[string]Binding
 	postBindFunc   func(Binding)
 	postUnbindFunc func(Binding)
 }
 
-// NewMockBindingService returns a new mock implementation of the BindingService
-func NewMockBindingService() BindingService {
+// NewMockBindingService returns a new mock implementation of the BindingService.
+// It hands out shared bindings and is not safe for concurrent use, use NewMemoryBindingService for that.
+func NewMockBindingService() ContextBindingService {
 	return &mockBindingService{
 		bindings: make(map[string]Binding),
 	}
@@ -180,7 +269,7 @@ func NewMockBindingService() BindingService {
 func (s *mockBindingService) New(id string) (Binding, error) {
 	b, ok := s.bindings[id]
 	if ok {
-		return b, errors.New("Binding already exists")
+		return b, ErrAlreadyExists
 	}
 
 	s.bindings[id] = newMockBinding(id, s.postBindFunc, s.postUnbindFunc)
@@ -191,7 +280,7 @@ func (s *mockBindingService) New(id string) (Binding, error) {
 func (s *mockBindingService) Get(id string) (Binding, error) {
 	b, ok := s.bindings[id]
 	if !ok {
-		return nil, errors.New("Binding not found")
+		return nil, ErrNotFound
 	}
 
 	return b, nil
@@ -200,12 +289,96 @@ func (s *mockBindingService) Get(id string) (Binding, error) {
 func (s *mockBindingService) Delete(id string) error {
 	_, ok := s.bindings[id]
 	if !ok {
-		return errors.New("Binding not found")
+		return ErrNotFound
 	}
 	delete(s.bindings, id)
 	return nil
 }
 
+func (s *mockBindingService) List(opts ListOptions) ([]Binding, string, error) {
+	ids := make([]string, 0, len(s.bindings))
+	for id := range s.bindings {
+		if id > opts.Cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	bindings := make([]Binding, 0)
+	for _, id := range ids {
+		b := s.bindings[id]
+		if !opts.Match(b) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(bindings) == opts.Limit {
+			return bindings, bindings[len(bindings)-1].ID(), nil
+		}
+
+		bindings = append(bindings, b)
+	}
+
+	return bindings, "", nil
+}
+
+func (s *mockBindingService) FindByRealm(thumbprint string) ([]Binding, error) {
+	bindings, _, err := s.List(ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (s *mockBindingService) FindByRealmName(name string) ([]Binding, error) {
+	bindings, _, err := s.List(ListOptions{Realm: name})
+	return bindings, err
+}
+
+func (s *mockBindingService) NewContext(ctx context.Context, id string) (Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.New(id)
+}
+
+func (s *mockBindingService) GetContext(ctx context.Context, id string) (Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.Get(id)
+}
+
+func (s *mockBindingService) DeleteContext(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.Delete(id)
+}
+
+func (s *mockBindingService) ListContext(ctx context.Context, opts ListOptions) ([]Binding, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return s.List(opts)
+}
+
+func (s *mockBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.FindByRealm(thumbprint)
+}
+
+func (s *mockBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]Binding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.FindByRealmName(name)
+}
+
 func (s *mockBindingService) SetPostBind(f func(Binding)) {
 	s.postBindFunc = f
 }
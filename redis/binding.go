@@ -0,0 +1,468 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	goredis "github.com/go-redis/redis/v8"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+type redisBinding struct {
+	svc            *redisBindingService
+	DBid           string
+	DBsecret       string
+	DBprevSecret   string
+	DBprevExpires  int64
+	DBpublicKey    string
+	DBkeyCreated   int64
+	DBretiredKeys  string
+	DBdescriptor   string
+	DBbinding      string
+	DBstatus       string
+	DBbindEndpoint string
+	DBrealmName    string
+	DBrealmKeyTP   string
+	secret         string
+}
+
+// fields returns the hash fields for the binding
+func (b *redisBinding) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"id":               b.DBid,
+		"secret":           b.DBsecret,
+		"previous_secret":  b.DBprevSecret,
+		"previous_expires": b.DBprevExpires,
+		"public_key":       b.DBpublicKey,
+		"key_created":      b.DBkeyCreated,
+		"retired_keys":     b.DBretiredKeys,
+		"descriptor":       b.DBdescriptor,
+		"binding":          b.DBbinding,
+		"status":           b.DBstatus,
+		"bind_endpoint":    b.DBbindEndpoint,
+		"realm_name":       b.DBrealmName,
+		"realm_thumbprint": b.DBrealmKeyTP,
+	}
+}
+
+// setFields populates the binding from the hash fields
+func (b *redisBinding) setFields(fields map[string]string) {
+	b.DBsecret = fields["secret"]
+	b.DBprevSecret = fields["previous_secret"]
+	b.DBprevExpires, _ = strconv.ParseInt(fields["previous_expires"], 10, 64)
+	b.DBpublicKey = fields["public_key"]
+	b.DBkeyCreated, _ = strconv.ParseInt(fields["key_created"], 10, 64)
+	b.DBretiredKeys = fields["retired_keys"]
+	b.DBdescriptor = fields["descriptor"]
+	b.DBbinding = fields["binding"]
+	b.DBstatus = fields["status"]
+	b.DBbindEndpoint = fields["bind_endpoint"]
+	b.DBrealmName = fields["realm_name"]
+	b.DBrealmKeyTP = fields["realm_thumbprint"]
+}
+
+// update runs f in a transaction with the current values of the requested hash fields.
+// Returns ErrNotFound if the binding has been deleted.
+func (b *redisBinding) update(ctx context.Context, fields []string, f func(old []string, pipe goredis.Pipeliner)) error {
+	key := b.svc.bindingKey(b.DBid)
+
+	return b.svc.transaction(ctx, b.DBid, func(tx *goredis.Tx) error {
+		vals, err := tx.HMGet(ctx, key, append([]string{"id"}, fields...)...).Result()
+		if err != nil {
+			return err
+		}
+		if vals[0] == nil {
+			return controller.ErrNotFound
+		}
+
+		old := make([]string, len(fields))
+		for i, v := range vals[1:] {
+			old[i], _ = v.(string)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			f(old, pipe)
+			return nil
+		})
+		return err
+	})
+}
+
+// set updates hash fields that are not part of any index
+func (b *redisBinding) set(ctx context.Context, values map[string]interface{}) error {
+	return b.update(ctx, nil, func(_ []string, pipe goredis.Pipeliner) {
+		pipe.HSet(ctx, b.svc.bindingKey(b.DBid), values)
+	})
+}
+
+// ID returns the ID of the binding.
+func (b *redisBinding) ID() string {
+	return b.DBid
+}
+
+// Secret for the binding.
+func (b *redisBinding) Secret() string {
+	return b.secret
+}
+
+// VerifySecret reports if the secret matches the binding secret, in constant time.
+func (b *redisBinding) VerifySecret(secret string) bool {
+	return controller.VerifyRotatedSecret(b.DBsecret, b.DBprevSecret, time.Unix(0, b.DBprevExpires), secret)
+}
+
+// RotateSecret replaces the binding secret, the previous secret is accepted for the grace period.
+func (b *redisBinding) RotateSecret(grace time.Duration) (string, error) {
+	return b.RotateSecretContext(context.Background(), grace)
+}
+
+// RotateSecretContext is like RotateSecret but with a context.
+func (b *redisBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	var previous string
+	expires := time.Now().Add(grace).UnixNano()
+	err = b.update(ctx, []string{"secret"}, func(old []string, pipe goredis.Pipeliner) {
+		previous = old[0]
+		pipe.HSet(ctx, b.svc.bindingKey(b.DBid), map[string]interface{}{
+			"previous_secret":  previous,
+			"previous_expires": expires,
+			"secret":           hash,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	b.DBprevSecret = previous
+	b.DBprevExpires = expires
+	b.DBsecret = hash
+	b.secret = secret
+
+	return secret, nil
+}
+
+// GenerateKey will generate a new keypair for this binding.
+func (b *redisBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyContext(context.Background(), svc, kek)
+}
+
+// GenerateKeyContext is like GenerateKey but with a context.
+func (b *redisBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyWithOptionsContext(ctx, svc, kek, controller.KeyOptions{})
+}
+
+// GenerateKeyWithOptions is like GenerateKey but generates the kind of key selected by the KeyOptions.
+func (b *redisBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return b.GenerateKeyWithOptionsContext(context.Background(), svc, kek, opts)
+}
+
+// GenerateKeyWithOptionsContext is like GenerateKeyWithOptions but with a context.
+func (b *redisBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return controller.ReplaceKey(svc, b.DBid, kek, opts, b.currentKey(), b.retiredKeys(), func(k controller.BindingKey, retired []controller.BindingKey) error {
+		bytes, err := json.Marshal(k.Key)
+		if err != nil {
+			return err
+		}
+
+		retiredBytes, err := json.Marshal(retired)
+		if err != nil {
+			return err
+		}
+
+		err = b.set(ctx, map[string]interface{}{
+			"public_key":   string(bytes),
+			"key_created":  k.Created.UnixNano(),
+			"retired_keys": string(retiredBytes),
+		})
+		if err != nil {
+			return err
+		}
+		b.DBpublicKey = string(bytes)
+		b.DBkeyCreated = k.Created.UnixNano()
+		b.DBretiredKeys = string(retiredBytes)
+
+		return nil
+	})
+}
+
+// PublicKey of the binding.
+func (b *redisBinding) PublicKey() *jose.JsonWebKey {
+	if b.DBpublicKey == "" {
+		return nil
+	}
+
+	key, _ := crypto.UnmarshalKey([]byte(b.DBpublicKey))
+	return key
+}
+
+// PublicKeys returns the current key followed by the retired keys, newest first.
+func (b *redisBinding) PublicKeys() []controller.BindingKey {
+	return controller.KeySet(b.currentKey(), b.retiredKeys())
+}
+
+func (b *redisBinding) currentKey() controller.BindingKey {
+	if b.DBpublicKey == "" {
+		return controller.BindingKey{}
+	}
+
+	return controller.BindingKey{
+		Key:     b.PublicKey(),
+		Created: time.Unix(0, b.DBkeyCreated),
+	}
+}
+
+func (b *redisBinding) retiredKeys() []controller.BindingKey {
+	retired := make([]controller.BindingKey, 0)
+	if b.DBretiredKeys != "" {
+		json.Unmarshal([]byte(b.DBretiredKeys), &retired)
+	}
+
+	return retired
+}
+
+// PrivateKey of the binding. Requires a StoredKeyService and a Key Encryption Key (KEK).
+func (b *redisBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.JsonWebKey, error) {
+	skey, err := svc.Get(b.DBid)
+	if err != nil {
+		return nil, err
+	}
+
+	return skey.Decrypt(kek)
+}
+
+// Signer returns a Signer for the private key operations of the binding from the KeyStore.
+func (b *redisBinding) Signer(ks controller.KeyStore) (controller.Signer, error) {
+	return ks.Signer(b)
+}
+
+// Descriptor returns the ControllerDescriptor for this binding
+func (b *redisBinding) Descriptor() document.ControllerDescriptor {
+	desc := document.ControllerDescriptor{}
+	json.Unmarshal([]byte(b.DBdescriptor), &desc)
+
+	return desc
+}
+
+// SetDescriptor sets the ControllerDescriptor for this binding
+func (b *redisBinding) SetDescriptor(desc document.ControllerDescriptor) error {
+	return b.SetDescriptorContext(context.Background(), desc)
+}
+
+// SetDescriptorContext is like SetDescriptor but with a context.
+func (b *redisBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
+	bytes, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+
+	if err = b.set(ctx, map[string]interface{}{"descriptor": string(bytes)}); err != nil {
+		return err
+	}
+	b.DBdescriptor = string(bytes)
+
+	return nil
+}
+
+// Bind is used when the realm binds to this binding.
+func (b *redisBinding) Bind(c *document.ControllerBinding) error {
+	return b.BindContext(context.Background(), c)
+}
+
+// BindContext is like Bind but with a context.
+func (b *redisBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	realmName, realmTP := "", ""
+	if c.RealmDescriptor != nil {
+		realmName = c.RealmDescriptor.Name
+		if c.RealmDescriptor.PublicKey != nil {
+			realmTP = crypto.Thumbprint(c.RealmDescriptor.PublicKey)
+		}
+	}
+
+	err = b.update(ctx, []string{"realm_name", "realm_thumbprint"}, func(old []string, pipe goredis.Pipeliner) {
+		b.svc.unindexRealm(ctx, pipe, b.DBid, old[0], old[1])
+		pipe.SAdd(ctx, b.svc.boundKey(), b.DBid)
+		if realmName != "" {
+			pipe.SAdd(ctx, b.svc.realmNameKey(realmName), b.DBid)
+		}
+		if realmTP != "" {
+			pipe.SAdd(ctx, b.svc.realmKey(realmTP), b.DBid)
+		}
+		pipe.HSet(ctx, b.svc.bindingKey(b.DBid), map[string]interface{}{
+			"binding":          string(bytes),
+			"realm_name":       realmName,
+			"realm_thumbprint": realmTP,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	b.DBbinding = string(bytes)
+	b.DBrealmName = realmName
+	b.DBrealmKeyTP = realmTP
+
+	if b.svc.postBindFunc != nil {
+		b.svc.postBindFunc(b)
+	}
+
+	return nil
+}
+
+// Unbind removes the realm binding
+func (b *redisBinding) Unbind() error {
+	return b.UnbindContext(context.Background())
+}
+
+// UnbindContext is like Unbind but with a context.
+func (b *redisBinding) UnbindContext(ctx context.Context) error {
+	if b.DBbinding == "" {
+		return controller.ErrNotBound
+	}
+
+	err := b.update(ctx, []string{"realm_name", "realm_thumbprint"}, func(old []string, pipe goredis.Pipeliner) {
+		b.svc.unindexRealm(ctx, pipe, b.DBid, old[0], old[1])
+		pipe.SRem(ctx, b.svc.boundKey(), b.DBid)
+		pipe.HSet(ctx, b.svc.bindingKey(b.DBid), map[string]interface{}{
+			"binding":          "",
+			"realm_name":       "",
+			"realm_thumbprint": "",
+		})
+	})
+	if err != nil {
+		return err
+	}
+	b.DBbinding = ""
+	b.DBrealmName = ""
+	b.DBrealmKeyTP = ""
+
+	if b.svc.postUnbindFunc != nil {
+		b.svc.postUnbindFunc(b)
+	}
+
+	return nil
+}
+
+// unindexRealm removes the binding from the realm indexes
+func (r *redisBindingService) unindexRealm(ctx context.Context, pipe goredis.Pipeliner, id, name, thumbprint string) {
+	if name != "" {
+		pipe.SRem(ctx, r.realmNameKey(name), id)
+	}
+	if thumbprint != "" {
+		pipe.SRem(ctx, r.realmKey(thumbprint), id)
+	}
+}
+
+// Certificate of the binding.
+func (b *redisBinding) Certificate() string {
+	c := b.ControllerBinding()
+	if c == nil {
+		return ""
+	}
+
+	return c.ControllerCertificate
+}
+
+// Mandates returns the mandates we got from the realm.
+func (b *redisBinding) Mandates() []string {
+	c := b.ControllerBinding()
+	if c == nil || c.Mandates == nil {
+		return []string{}
+	}
+
+	return c.Mandates
+}
+
+// AdminRoles returns the list of roles that can administer this binding.
+func (b *redisBinding) AdminRoles() []string {
+	c := b.ControllerBinding()
+	if c == nil || c.AdminRoles == nil {
+		return []string{}
+	}
+
+	return c.AdminRoles
+}
+
+// Realm returns the realm-descriptor that was used for this binding.
+func (b *redisBinding) Realm() *document.RealmDescriptor {
+	c := b.ControllerBinding()
+	if c == nil {
+		return nil
+	}
+
+	return c.RealmDescriptor
+}
+
+// ControllerBinding returns the controller-binding document.
+func (b *redisBinding) ControllerBinding() *document.ControllerBinding {
+	if b.DBbinding == "" {
+		return nil
+	}
+
+	c := &document.ControllerBinding{}
+	if err := json.Unmarshal([]byte(b.DBbinding), &c); err != nil {
+		return nil
+	}
+
+	return c
+}
+
+// Status is used to tell the realm if this binding requires some extra setup steps.
+func (b *redisBinding) Status() string {
+	return b.DBstatus
+}
+
+// SetStatus updates the Status value.
+func (b *redisBinding) SetStatus(v string) error {
+	return b.SetStatusContext(context.Background(), v)
+}
+
+// SetStatusContext is like SetStatus but with a context.
+func (b *redisBinding) SetStatusContext(ctx context.Context, v string) error {
+	err := b.update(ctx, []string{"status"}, func(old []string, pipe goredis.Pipeliner) {
+		if old[0] != "" {
+			pipe.SRem(ctx, b.svc.statusKey(old[0]), b.DBid)
+		}
+		if v != "" {
+			pipe.SAdd(ctx, b.svc.statusKey(v), b.DBid)
+		}
+		pipe.HSet(ctx, b.svc.bindingKey(b.DBid), "status", v)
+	})
+	if err != nil {
+		return err
+	}
+	b.DBstatus = v
+
+	return nil
+}
+
+// BindEndpoint returns the endpoint where the realm should post the controller-binding.
+func (b *redisBinding) BindEndpoint() string {
+	return b.DBbindEndpoint
+}
+
+// SetBindEndpoint updates the BindEndpoint value.
+func (b *redisBinding) SetBindEndpoint(v string) error {
+	return b.SetBindEndpointContext(context.Background(), v)
+}
+
+// SetBindEndpointContext is like SetBindEndpoint but with a context.
+func (b *redisBinding) SetBindEndpointContext(ctx context.Context, v string) error {
+	if err := b.set(ctx, map[string]interface{}{"bind_endpoint": v}); err != nil {
+		return err
+	}
+	b.DBbindEndpoint = v
+
+	return nil
+}
@@ -0,0 +1,339 @@
+package redis
+
+import (
+	"context"
+	"sort"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// listBatchSize is the number of IDs fetched per round-trip when listing bindings
+const listBatchSize = 100
+
+// maxRetries is the number of times an optimistic transaction is retried when a watched key changed
+const maxRetries = 10
+
+type redisBindingService struct {
+	client         goredis.UniversalClient
+	prefix         string
+	postBindFunc   func(controller.Binding)
+	postUnbindFunc func(controller.Binding)
+}
+
+// New returns a BindingService that stores the bindings in Redis.
+// Each binding is stored as a hash, with sets used as indexes for status and realm lookups.
+// All keys are prefixed with prefix, so several controllers can share a database.
+func New(client goredis.UniversalClient, prefix string) controller.ContextBindingService {
+	return &redisBindingService{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (r *redisBindingService) bindingKey(id string) string {
+	return r.prefix + "binding:" + id
+}
+
+func (r *redisBindingService) allKey() string {
+	return r.prefix + "bindings"
+}
+
+func (r *redisBindingService) boundKey() string {
+	return r.prefix + "bound"
+}
+
+func (r *redisBindingService) statusKey(status string) string {
+	return r.prefix + "status:" + status
+}
+
+func (r *redisBindingService) realmKey(thumbprint string) string {
+	return r.prefix + "realm:" + thumbprint
+}
+
+func (r *redisBindingService) realmNameKey(name string) string {
+	return r.prefix + "realm-name:" + name
+}
+
+// transaction runs f in an optimistic transaction watching the binding key, retrying if it was changed
+func (r *redisBindingService) transaction(ctx context.Context, id string, f func(*goredis.Tx) error) error {
+	for i := 0; i < maxRetries; i++ {
+		err := r.client.Watch(ctx, f, r.bindingKey(id))
+		if err != goredis.TxFailedErr {
+			return err
+		}
+	}
+
+	return goredis.TxFailedErr
+}
+
+func (r *redisBindingService) SetPostBind(f func(controller.Binding)) {
+	r.postBindFunc = f
+}
+
+func (r *redisBindingService) PostBind() func(controller.Binding) {
+	return r.postBindFunc
+}
+
+func (r *redisBindingService) SetPostUnbind(f func(controller.Binding)) {
+	r.postUnbindFunc = f
+}
+
+func (r *redisBindingService) PostUnbind() func(controller.Binding) {
+	return r.postUnbindFunc
+}
+
+func (r *redisBindingService) New(id string) (controller.Binding, error) {
+	return r.NewContext(context.Background(), id)
+}
+
+func (r *redisBindingService) NewContext(ctx context.Context, id string) (controller.Binding, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &redisBinding{
+		svc:      r,
+		DBid:     id,
+		DBsecret: hash,
+		secret:   secret,
+	}
+
+	err = r.transaction(ctx, id, func(tx *goredis.Tx) error {
+		n, err := tx.Exists(ctx, r.bindingKey(id)).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return controller.ErrAlreadyExists
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, r.bindingKey(id), b.fields())
+			pipe.ZAdd(ctx, r.allKey(), &goredis.Z{Member: id})
+			return nil
+		})
+		return err
+	})
+	if err == controller.ErrAlreadyExists {
+		existing, getErr := r.GetContext(ctx, id)
+		if getErr != nil {
+			return nil, getErr
+		}
+		return existing, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (r *redisBindingService) Get(id string) (controller.Binding, error) {
+	return r.GetContext(context.Background(), id)
+}
+
+func (r *redisBindingService) GetContext(ctx context.Context, id string) (controller.Binding, error) {
+	bindings, err := r.load(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+
+	if bindings[0] == nil {
+		return nil, controller.ErrNotFound
+	}
+
+	return bindings[0], nil
+}
+
+// load fetches the bindings with the given IDs, the result has a nil entry for IDs that don't exist
+func (r *redisBindingService) load(ctx context.Context, ids []string) ([]*redisBinding, error) {
+	cmds := make([]*goredis.StringStringMapCmd, len(ids))
+	_, err := r.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, r.bindingKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bindings := make([]*redisBinding, len(ids))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+
+		bindings[i] = &redisBinding{
+			svc:  r,
+			DBid: ids[i],
+		}
+		bindings[i].setFields(fields)
+	}
+
+	return bindings, nil
+}
+
+func (r *redisBindingService) Delete(id string) error {
+	return r.DeleteContext(context.Background(), id)
+}
+
+func (r *redisBindingService) DeleteContext(ctx context.Context, id string) error {
+	return r.transaction(ctx, id, func(tx *goredis.Tx) error {
+		vals, err := tx.HMGet(ctx, r.bindingKey(id), "id", "status", "realm_name", "realm_thumbprint").Result()
+		if err != nil {
+			return err
+		}
+		if vals[0] == nil {
+			return controller.ErrNotFound
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Del(ctx, r.bindingKey(id))
+			pipe.ZRem(ctx, r.allKey(), id)
+			pipe.SRem(ctx, r.boundKey(), id)
+			if status, _ := vals[1].(string); status != "" {
+				pipe.SRem(ctx, r.statusKey(status), id)
+			}
+			if name, _ := vals[2].(string); name != "" {
+				pipe.SRem(ctx, r.realmNameKey(name), id)
+			}
+			if tp, _ := vals[3].(string); tp != "" {
+				pipe.SRem(ctx, r.realmKey(tp), id)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+func (r *redisBindingService) List(opts controller.ListOptions) ([]controller.Binding, string, error) {
+	return r.ListContext(context.Background(), opts)
+}
+
+func (r *redisBindingService) ListContext(ctx context.Context, opts controller.ListOptions) ([]controller.Binding, string, error) {
+	page := &listPage{
+		opts:     opts,
+		bindings: make([]controller.Binding, 0),
+	}
+
+	indexes := make([]string, 0)
+	if opts.Status != "" {
+		indexes = append(indexes, r.statusKey(opts.Status))
+	}
+	if opts.Realm != "" {
+		indexes = append(indexes, r.realmNameKey(opts.Realm))
+	}
+	if opts.RealmThumbprint != "" {
+		indexes = append(indexes, r.realmKey(opts.RealmThumbprint))
+	}
+	if opts.Bound != nil && *opts.Bound {
+		indexes = append(indexes, r.boundKey())
+	}
+
+	if len(indexes) > 0 {
+		members, err := r.client.SInter(ctx, indexes...).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Strings(members)
+
+		ids := make([]string, 0, len(members))
+		for _, id := range members {
+			if id > opts.Cursor {
+				ids = append(ids, id)
+			}
+		}
+
+		for len(ids) > 0 && !page.done {
+			n := listBatchSize
+			if n > len(ids) {
+				n = len(ids)
+			}
+			if err := r.collect(ctx, page, ids[:n]); err != nil {
+				return nil, "", err
+			}
+			ids = ids[n:]
+		}
+
+		return page.bindings, page.cursor, nil
+	}
+
+	min := "-"
+	if opts.Cursor != "" {
+		min = "(" + opts.Cursor
+	}
+	for !page.done {
+		ids, err := r.client.ZRangeByLex(ctx, r.allKey(), &goredis.ZRangeBy{
+			Min:   min,
+			Max:   "+",
+			Count: listBatchSize,
+		}).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		if err := r.collect(ctx, page, ids); err != nil {
+			return nil, "", err
+		}
+		min = "(" + ids[len(ids)-1]
+	}
+
+	return page.bindings, page.cursor, nil
+}
+
+// listPage collects the bindings for one call to List
+type listPage struct {
+	opts     controller.ListOptions
+	bindings []controller.Binding
+	cursor   string
+	done     bool
+}
+
+// collect loads the bindings with the given IDs and adds the ones matching the filters to the page
+func (r *redisBindingService) collect(ctx context.Context, page *listPage, ids []string) error {
+	bindings, err := r.load(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bindings {
+		if b == nil || !page.opts.Match(b) {
+			continue
+		}
+
+		if page.opts.Limit > 0 && len(page.bindings) == page.opts.Limit {
+			page.cursor = page.bindings[len(page.bindings)-1].ID()
+			page.done = true
+			return nil
+		}
+
+		page.bindings = append(page.bindings, b)
+	}
+
+	return nil
+}
+
+func (r *redisBindingService) FindByRealm(thumbprint string) ([]controller.Binding, error) {
+	return r.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (r *redisBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]controller.Binding, error) {
+	bindings, _, err := r.ListContext(ctx, controller.ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (r *redisBindingService) FindByRealmName(name string) ([]controller.Binding, error) {
+	return r.FindByRealmNameContext(context.Background(), name)
+}
+
+func (r *redisBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]controller.Binding, error) {
+	bindings, _, err := r.ListContext(ctx, controller.ListOptions{Realm: name})
+	return bindings, err
+}
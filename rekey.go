@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+
+	keys "github.com/Brickchain/go-keys.v1"
+	"github.com/pkg/errors"
+)
+
+// RekeyOptions controls a run of RekeyBindings.
+type RekeyOptions struct {
+	// Cursor resumes an interrupted run after the binding with this ID, see RekeyResult.Cursor.
+	Cursor string
+
+	// BatchSize is the number of bindings listed at a time, defaults to 100.
+	BatchSize int
+
+	// Checkpoint is called with the cursor after each binding has been re-encrypted,
+	// so the cursor can be persisted and used to resume the run.
+	Checkpoint func(cursor string)
+}
+
+// RekeyResult reports what RekeyBindings did.
+type RekeyResult struct {
+	// Cursor is the ID of the last binding that was completed.
+	Cursor string
+
+	// Rekeyed is the number of stored keys that were re-encrypted with the new KEK.
+	Rekeyed int
+
+	// Skipped is the number of stored keys that were already encrypted with the new KEK.
+	Skipped int
+}
+
+// RekeyBindings re-encrypts the stored private keys of all bindings, including their retired keys,
+// from oldKEK to newKEK. Keys that can already be decrypted with newKEK are left as is, so a run
+// that was interrupted can be started again, either from the beginning or from RekeyResult.Cursor.
+// On failure the result tells how far the run got.
+//
+// Only the private keys in the StoredKeyService are covered. The KEK a gorm BindingService encrypts its
+// columns with (gorm.NewEncrypted) is separate and is not changed by RekeyBindings.
+func RekeyBindings(ctx context.Context, bsvc BindingService, ksvc keys.StoredKeyService, oldKEK, newKEK []byte, opts RekeyOptions) (RekeyResult, error) {
+	res := RekeyResult{
+		Cursor: opts.Cursor,
+	}
+
+	batch := opts.BatchSize
+	if batch <= 0 {
+		batch = 100
+	}
+
+	err := walkBindings(ctx, bsvc, ListOptions{Cursor: opts.Cursor, Limit: batch}, func(b Binding) error {
+		for _, k := range b.PublicKeys() {
+			id := b.ID()
+			if !k.Active() {
+				id = RetiredKeyID(b.ID(), k.Key)
+			}
+
+			rekeyed, err := rekeyStoredKey(ksvc, id, oldKEK, newKEK)
+			if err != nil {
+				return errors.Wrapf(err, "failed to re-encrypt key %s", id)
+			}
+			if rekeyed {
+				res.Rekeyed++
+			} else {
+				res.Skipped++
+			}
+		}
+
+		res.Cursor = b.ID()
+		if opts.Checkpoint != nil {
+			opts.Checkpoint(res.Cursor)
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// rekeyStoredKey re-encrypts a stored key with newKEK, and reports false if it already was
+func rekeyStoredKey(ksvc keys.StoredKeyService, id string, oldKEK, newKEK []byte) (bool, error) {
+	skey, err := ksvc.Get(id)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := skey.Decrypt(newKEK); err == nil {
+		return false, nil
+	}
+
+	key, err := skey.Decrypt(oldKEK)
+	if err != nil {
+		return false, err
+	}
+
+	// the StoredKey from Get may be the stored object itself, so a failed Save must not leave it changed
+	rekeyed := &keys.StoredKey{
+		ID: skey.ID,
+	}
+
+	if err = rekeyed.Encrypt(key, newKEK); err != nil {
+		return false, err
+	}
+
+	if err = ksvc.Save(rekeyed); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,150 @@
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// failingKeyService fails Save once the number of saves reaches failAt
+type failingKeyService struct {
+	keys.StoredKeyService
+	saves  int
+	failAt int
+}
+
+func (f *failingKeyService) Save(k *keys.StoredKey) error {
+	f.saves++
+	if f.failAt > 0 && f.saves >= f.failAt {
+		return errors.New("key store unavailable")
+	}
+
+	return f.StoredKeyService.Save(k)
+}
+
+func Test_RekeyBindings(t *testing.T) {
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			bsvc := svc.Create(t)
+			ksvc := keys.NewMockStoredKeyService()
+			oldKEK := crypto.NewSymmetricKey(jose.A256KW)
+			newKEK := crypto.NewSymmetricKey(jose.A256KW)
+
+			// binding-0 has no key, binding-1 one key, binding-2 one retired key and binding-3 two retired keys
+			ids := make([]string, 0)
+			for i := 0; i < 4; i++ {
+				b, err := bsvc.New(fmt.Sprintf("binding-%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for j := 0; j < i && j < 3; j++ {
+					b, _ = bsvc.Get(b.ID())
+					if err := b.GenerateKey(ksvc, oldKEK); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if i > 0 {
+					b, _ = bsvc.Get(b.ID())
+					for _, k := range b.PublicKeys() {
+						id := b.ID()
+						if !k.Active() {
+							id = controller.RetiredKeyID(b.ID(), k.Key)
+						}
+						ids = append(ids, id)
+					}
+				}
+			}
+			if len(ids) != 6 {
+				t.Fatalf("%d stored keys, want 6", len(ids))
+			}
+
+			failing := &failingKeyService{StoredKeyService: ksvc, failAt: 3}
+			checkpoints := make([]string, 0)
+			res, err := controller.RekeyBindings(context.Background(), bsvc, failing, oldKEK, newKEK, controller.RekeyOptions{
+				BatchSize:  2,
+				Checkpoint: func(c string) { checkpoints = append(checkpoints, c) },
+			})
+			if err == nil {
+				t.Fatal("RekeyBindings() with failing key store should fail")
+			}
+			if res.Cursor != "binding-1" || res.Rekeyed != 2 {
+				t.Errorf("RekeyBindings() = %+v, want cursor binding-1 after 2 keys", res)
+			}
+			if len(checkpoints) != 2 || checkpoints[1] != res.Cursor {
+				t.Errorf("checkpoints = %v, want [binding-0 binding-1]", checkpoints)
+			}
+
+			res, err = controller.RekeyBindings(context.Background(), bsvc, ksvc, oldKEK, newKEK, controller.RekeyOptions{Cursor: res.Cursor, BatchSize: 2})
+			if err != nil {
+				t.Fatalf("RekeyBindings() resume error = %v", err)
+			}
+			// the current key of binding-2 was re-encrypted before the failure
+			if res.Cursor != "binding-3" || res.Rekeyed != 4 || res.Skipped != 1 {
+				t.Errorf("RekeyBindings() resume = %+v, want 4 rekeyed and 1 skipped", res)
+			}
+
+			for _, id := range ids {
+				skey, err := ksvc.Get(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := skey.Decrypt(newKEK); err != nil {
+					t.Errorf("key %s not encrypted with the new KEK: %v", id, err)
+				}
+			}
+
+			res, err = controller.RekeyBindings(context.Background(), bsvc, ksvc, oldKEK, newKEK, controller.RekeyOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Rekeyed != 0 || res.Skipped != len(ids) {
+				t.Errorf("RekeyBindings() second run = %+v, want all %d keys skipped", res, len(ids))
+			}
+		})
+	}
+}
+
+// sharedKeyService hands out the stored StoredKey itself and fails every Save
+type sharedKeyService struct {
+	keys map[string]*keys.StoredKey
+}
+
+func (s *sharedKeyService) Save(k *keys.StoredKey) error {
+	return errors.New("key store unavailable")
+}
+
+func (s *sharedKeyService) Get(id string) (*keys.StoredKey, error) {
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+
+	return k, nil
+}
+
+func Test_RekeyBindings_FailedSave(t *testing.T) {
+	bsvc := controller.NewMemoryBindingService()
+	ksvc := keys.NewMockStoredKeyService()
+	oldKEK := crypto.NewSymmetricKey(jose.A256KW)
+	newKEK := crypto.NewSymmetricKey(jose.A256KW)
+
+	b, _ := bsvc.New("test")
+	if err := b.GenerateKey(ksvc, oldKEK); err != nil {
+		t.Fatal(err)
+	}
+	skey, _ := ksvc.Get("test")
+	shared := &sharedKeyService{keys: map[string]*keys.StoredKey{"test": skey}}
+
+	if _, err := controller.RekeyBindings(context.Background(), bsvc, shared, oldKEK, newKEK, controller.RekeyOptions{}); err == nil {
+		t.Fatal("RekeyBindings() with failing key store should fail")
+	}
+	if _, err := skey.Decrypt(oldKEK); err != nil {
+		t.Errorf("stored key changed by a failed Save: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultReplayCacheSize is the number of tokens remembered by NewMemoryReplayCache when no size is given.
+const DefaultReplayCacheSize = 10000
+
+// ReplayCache remembers the mandate-tokens accepted by the verifier, so a token can only be used once.
+// Implementations backed by a shared store let several controller instances reject each others tokens.
+type ReplayCache interface {
+	// Seen records the token ID until expires and reports if it was already recorded and has not expired at now.
+	// Checking and recording has to be atomic, so the same token is never accepted twice.
+	Seen(id string, now, expires time.Time) (bool, error)
+}
+
+// replayEntry is a token ID in the memoryReplayCache
+type replayEntry struct {
+	id      string
+	expires time.Time
+}
+
+// memoryReplayCache is an in-memory ReplayCache that holds each token ID until it expires,
+// dropping the oldest expired tokens to make room
+type memoryReplayCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryReplayCache returns an in-memory ReplayCache that remembers up to size tokens.
+// A token is never forgotten before it expires: when the oldest token has not expired yet the cache
+// is full and Seen returns ErrReplayCacheFull, so new tokens are rejected until it does. size should
+// exceed the number of tokens accepted within VerifyOptions.MaxTTL, which bounds how long a token is held.
+func NewMemoryReplayCache(size int) ReplayCache {
+	if size <= 0 {
+		size = DefaultReplayCacheSize
+	}
+
+	return &memoryReplayCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryReplayCache) Seen(id string, now, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[id]; ok {
+		e := el.Value.(*replayEntry)
+		if e.expires.After(now) {
+			return true, nil
+		}
+		e.expires = expires
+		m.order.MoveToFront(el)
+		return false, nil
+	}
+
+	for m.order.Len() >= m.size {
+		el := m.order.Back()
+		e := el.Value.(*replayEntry)
+		if e.expires.After(now) {
+			return false, ErrReplayCacheFull
+		}
+		m.order.Remove(el)
+		delete(m.entries, e.id)
+	}
+
+	m.entries[id] = m.order.PushFront(&replayEntry{id: id, expires: expires})
+
+	return false, nil
+}
@@ -0,0 +1,50 @@
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+)
+
+func Test_MemoryReplayCache(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache := controller.NewMemoryReplayCache(2)
+
+	seen := func(id string, now time.Time) bool {
+		t.Helper()
+		s, err := cache.Seen(id, now, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if seen("a", now) {
+		t.Error("Seen(a) first time = true")
+	}
+	if !seen("a", now.Add(time.Second)) {
+		t.Error("Seen(a) within expiry = false")
+	}
+	if seen("a", now.Add(2*time.Minute)) {
+		t.Error("Seen(a) after expiry = true")
+	}
+
+	now = now.Add(2 * time.Minute)
+	seen("b", now)
+	if _, err := cache.Seen("c", now, now.Add(time.Minute)); err != controller.ErrReplayCacheFull {
+		t.Errorf("Seen(c) on full cache error = %v, want %v", err, controller.ErrReplayCacheFull)
+	}
+	for _, id := range []string{"a", "b"} {
+		if !seen(id, now.Add(time.Second)) {
+			t.Errorf("Seen(%s) = false, unexpired token forgotten on full cache", id)
+		}
+	}
+
+	if seen("c", now.Add(2*time.Minute)) {
+		t.Error("Seen(c) after the others expired = true")
+	}
+	if !seen("c", now.Add(2*time.Minute+time.Second)) {
+		t.Error("Seen(c) within expiry = false")
+	}
+}
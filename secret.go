@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/Brickchain/go-crypto.v2"
+)
+
+// secretHashPrefix marks a secret stored by HashSecret
+const secretHashPrefix = "sha256$"
+
+// NewSecret generates a new binding secret and the salted hash that the backends store in its place.
+func NewSecret() (secret, hash string, err error) {
+	secret, err = crypto.GenerateRandomString(42)
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err = HashSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, hash, nil
+}
+
+// HashSecret returns a salted hash of the secret, in the form sha256$<salt>$<hash>.
+// Binding secrets are long random strings, so a single salted SHA-256 is sufficient.
+func HashSecret(secret string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	return secretHashPrefix + encodeSecretHash(salt, secret), nil
+}
+
+// VerifySecretHash reports if the secret matches a hash returned by HashSecret, in constant time.
+// Secrets stored in plaintext before hashing was introduced are compared as is.
+func VerifySecretHash(hash, secret string) bool {
+	if hash == "" || secret == "" {
+		return false
+	}
+
+	if !IsSecretHash(hash) {
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(secret)) == 1
+	}
+
+	parts := strings.Split(strings.TrimPrefix(hash, secretHashPrefix), "$")
+	if len(parts) != 2 {
+		return false
+	}
+
+	salt, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(secretHashPrefix+encodeSecretHash(salt, secret))) == 1
+}
+
+// VerifyRotatedSecret is like VerifySecretHash but also accepts the previous secret until it expires.
+// Both hashes are always checked so the time taken does not reveal which one matched.
+func VerifyRotatedSecret(hash, previousHash string, previousExpires time.Time, secret string) bool {
+	current := VerifySecretHash(hash, secret)
+	previous := VerifySecretHash(previousHash, secret) && time.Now().Before(previousExpires)
+
+	return current || previous
+}
+
+// IsSecretHash reports if the stored value is a hash returned by HashSecret
+func IsSecretHash(v string) bool {
+	return strings.HasPrefix(v, secretHashPrefix)
+}
+
+func encodeSecretHash(salt []byte, secret string) string {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), secret...))
+
+	return base64.RawURLEncoding.EncodeToString(salt) + "$" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,20 +1,148 @@
 package controller
 
+import (
+	"context"
+
+	"github.com/Brickchain/go-crypto.v2"
+)
+
 // BindingService describes the methods needed to manage bindings
 type BindingService interface {
 	// New creates a new Binding with an ID.
-	// Trying to create a binding with an already existing ID should return an error.
+	// Trying to create a binding with an already existing ID should return ErrAlreadyExists.
 	New(id string) (Binding, error)
 
-	// Get a Binding by ID
+	// Get a Binding by ID. Returns ErrNotFound if the Binding does not exist.
 	Get(id string) (Binding, error)
 
-	// Delete a Binding by ID
+	// Delete a Binding by ID. Returns ErrNotFound if the Binding does not exist.
 	Delete(id string) error
 
+	// List returns the Bindings matching the filters in ListOptions, ordered by ID.
+	// The returned cursor should be passed in the next call to get the following page,
+	// it is empty when there are no more Bindings to fetch.
+	List(opts ListOptions) (bindings []Binding, cursor string, err error)
+
+	// FindByRealm returns all Bindings bound to the realm with the given public-key thumbprint.
+	FindByRealm(thumbprint string) ([]Binding, error)
+
+	// FindByRealmName returns all Bindings bound to the realm with the given name.
+	FindByRealmName(name string) ([]Binding, error)
+
 	// SetPostBind is run after a Binding has been bound by a Realm
 	SetPostBind(func(Binding))
 
 	// SetPostUnbind is run after a Binding has been unbound by a Realm
 	SetPostUnbind(func(Binding))
 }
+
+// ContextBindingService is a BindingService where the operations also accept a context.Context.
+// The context is used for cancellation and deadlines of the underlying storage calls,
+// and the returned Bindings implement ContextBinding.
+type ContextBindingService interface {
+	BindingService
+
+	// NewContext is like New but with a context.
+	NewContext(ctx context.Context, id string) (Binding, error)
+
+	// GetContext is like Get but with a context.
+	GetContext(ctx context.Context, id string) (Binding, error)
+
+	// DeleteContext is like Delete but with a context.
+	DeleteContext(ctx context.Context, id string) error
+
+	// ListContext is like List but with a context.
+	ListContext(ctx context.Context, opts ListOptions) (bindings []Binding, cursor string, err error)
+
+	// FindByRealmContext is like FindByRealm but with a context.
+	FindByRealmContext(ctx context.Context, thumbprint string) ([]Binding, error)
+
+	// FindByRealmNameContext is like FindByRealmName but with a context.
+	FindByRealmNameContext(ctx context.Context, name string) ([]Binding, error)
+}
+
+// ListOptions holds the pagination and filter settings for BindingService.List
+type ListOptions struct {
+	// Cursor returned from the previous call to List. Empty means start from the beginning.
+	Cursor string
+
+	// Limit is the maximum number of Bindings to return. Zero means no limit.
+	Limit int
+
+	// Status only includes Bindings with this status.
+	Status string
+
+	// Bound only includes bound (true) or unbound (false) Bindings. Nil includes both.
+	Bound *bool
+
+	// Realm only includes Bindings bound to the realm with this name.
+	Realm string
+
+	// RealmThumbprint only includes Bindings bound to the realm with this public-key thumbprint.
+	RealmThumbprint string
+}
+
+// Match reports if the Binding passes the filters in ListOptions. Pagination is not considered.
+func (o ListOptions) Match(b Binding) bool {
+	if o.Status != "" && b.Status() != o.Status {
+		return false
+	}
+
+	bound := b.ControllerBinding() != nil
+	if o.Bound != nil && *o.Bound != bound {
+		return false
+	}
+
+	if o.Realm != "" || o.RealmThumbprint != "" {
+		realm := b.Realm()
+		if realm == nil {
+			return false
+		}
+
+		if o.Realm != "" && realm.Name != o.Realm {
+			return false
+		}
+
+		if o.RealmThumbprint != "" && (realm.PublicKey == nil || crypto.Thumbprint(realm.PublicKey) != o.RealmThumbprint) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// walkBindings calls f for each binding matching opts, listing opts.Limit bindings at a time,
+// until f returns an error or all bindings have been visited
+func walkBindings(ctx context.Context, bsvc BindingService, opts ListOptions, f func(Binding) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var bindings []Binding
+		var cursor string
+		var err error
+		if c, ok := bsvc.(ContextBindingService); ok {
+			bindings, cursor, err = c.ListContext(ctx, opts)
+		} else {
+			bindings, cursor, err = bsvc.List(opts)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, b := range bindings {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := f(b); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "" {
+			return nil
+		}
+		opts.Cursor = cursor
+	}
+}
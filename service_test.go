@@ -1,15 +1,29 @@
 package controller_test
 
 import (
+	"context"
+	"database/sql"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-controller.v2/controllertest"
+	"github.com/Brickchain/go-crypto.v2"
 	"github.com/Brickchain/go-document.v2"
 
+	boltcontroller "github.com/Brickchain/go-controller.v2/bolt"
+	filecontroller "github.com/Brickchain/go-controller.v2/file"
 	gormcontroller "github.com/Brickchain/go-controller.v2/gorm"
+	rediscontroller "github.com/Brickchain/go-controller.v2/redis"
+	sqlcontroller "github.com/Brickchain/go-controller.v2/sqldb"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	bbolt "go.etcd.io/bbolt"
 )
 
 type service struct {
@@ -29,6 +43,13 @@ func TestMain(m *testing.M) {
 		},
 	})
 
+	services = append(services, &service{
+		Name: "Memory",
+		Create: func(t *testing.T) controller.BindingService {
+			return controller.NewMemoryBindingService()
+		},
+	})
+
 	services = append(services, &service{
 		Name: "Gorm",
 		Create: func(t *testing.T) controller.BindingService {
@@ -36,14 +57,104 @@ func TestMain(m *testing.M) {
 			if err != nil {
 				t.Fatal(err)
 			}
+			// every connection to :memory: is a separate database
+			db.DB().SetMaxOpenConns(1)
 
 			return gormcontroller.New(db)
 		},
 	})
 
+	services = append(services, &service{
+		Name: "EncryptedGorm",
+		Create: func(t *testing.T) controller.BindingService {
+			db, err := gorm.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.DB().SetMaxOpenConns(1)
+
+			return gormcontroller.NewEncrypted(db, []byte("0123456789abcdef0123456789abcdef"))
+		},
+	})
+
+	services = append(services, &service{
+		Name: "Bolt",
+		Create: func(t *testing.T) controller.BindingService {
+			db, err := bbolt.Open(filepath.Join(t.TempDir(), "bindings.db"), 0600, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+
+			svc, err := boltcontroller.New(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return svc
+		},
+	})
+
+	services = append(services, &service{
+		Name: "SQL",
+		Create: func(t *testing.T) controller.BindingService {
+			db, err := sql.Open("sqlite3", ":memory:")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.SetMaxOpenConns(1)
+			t.Cleanup(func() { db.Close() })
+
+			svc, err := sqlcontroller.New(db, sqlcontroller.SQLite)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return svc
+		},
+	})
+
+	services = append(services, &service{
+		Name: "Redis",
+		Create: func(t *testing.T) controller.BindingService {
+			server, err := miniredis.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(server.Close)
+
+			client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { client.Close() })
+
+			return rediscontroller.New(client, "controller:")
+		},
+	})
+
+	services = append(services, &service{
+		Name: "File",
+		Create: func(t *testing.T) controller.BindingService {
+			svc, err := filecontroller.New(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return svc
+		},
+	})
+
 	os.Exit(m.Run())
 }
 
+func Test_BindingService_Suite(t *testing.T) {
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			controllertest.RunBindingServiceSuiteWithOptions(t, svc.Create, controllertest.SuiteOptions{
+				SkipConcurrency: svc.Name == "Mock",
+			})
+		})
+	}
+}
+
 func Test_BindingService_New(t *testing.T) {
 	type test struct {
 		name    string
@@ -262,3 +373,313 @@ func Test_BindingService_SetPostUnbind(t *testing.T) {
 		})
 	}
 }
+
+func Test_BindingService_List(t *testing.T) {
+	bound := true
+	unbound := false
+	type test struct {
+		name    string
+		svc     controller.BindingService
+		opts    controller.ListOptions
+		pages   [][]string
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name:  "All",
+			pages: [][]string{{"a", "b", "c", "d"}},
+		},
+		{
+			name:  "Paginated",
+			opts:  controller.ListOptions{Limit: 3},
+			pages: [][]string{{"a", "b", "c"}, {"d"}},
+		},
+		{
+			name:  "Exact_Page",
+			opts:  controller.ListOptions{Limit: 2},
+			pages: [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:  "Status",
+			opts:  controller.ListOptions{Status: "setup_required"},
+			pages: [][]string{{"b", "d"}},
+		},
+		{
+			name:  "Bound",
+			opts:  controller.ListOptions{Bound: &bound},
+			pages: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:  "Unbound",
+			opts:  controller.ListOptions{Bound: &unbound},
+			pages: [][]string{{"d"}},
+		},
+		{
+			name:  "Realm_Paginated",
+			opts:  controller.ListOptions{Realm: "example.com", Limit: 1},
+			pages: [][]string{{"a"}, {"c"}},
+		},
+		{
+			name:  "No_Match",
+			opts:  controller.ListOptions{Realm: "example.org", Status: "setup_required"},
+			pages: [][]string{{}},
+		},
+	}
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.svc = svc.Create(t)
+					for _, id := range []string{"d", "c", "b", "a"} {
+						if _, err := tt.svc.New(id); err != nil {
+							t.Fatal(err)
+						}
+					}
+					for id, realm := range map[string]string{"a": "example.com", "b": "example.net", "c": "example.com"} {
+						b, _ := tt.svc.Get(id)
+						if err := b.Bind(&document.ControllerBinding{RealmDescriptor: &document.RealmDescriptor{Name: realm}}); err != nil {
+							t.Fatal(err)
+						}
+					}
+					for _, id := range []string{"b", "d"} {
+						b, _ := tt.svc.Get(id)
+						if err := b.SetStatus("setup_required"); err != nil {
+							t.Fatal(err)
+						}
+					}
+
+					opts := tt.opts
+					for i, page := range tt.pages {
+						got, cursor, err := tt.svc.List(opts)
+						if (err != nil) != tt.wantErr {
+							t.Fatalf("BindingService.List() error = %v, wantErr %v", err, tt.wantErr)
+						}
+						ids := make([]string, 0)
+						for _, b := range got {
+							ids = append(ids, b.ID())
+						}
+						if strings.Join(ids, ",") != strings.Join(page, ",") {
+							t.Errorf("BindingService.List() page %d = %v, want %v", i, ids, page)
+						}
+						if last := i == len(tt.pages)-1; last != (cursor == "") {
+							t.Errorf("BindingService.List() page %d cursor = %q, last page = %v", i, cursor, last)
+						}
+						opts.Cursor = cursor
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_BindingService_FindByRealm(t *testing.T) {
+	realmKey, err := crypto.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+
+	type test struct {
+		name    string
+		svc     controller.BindingService
+		prepare func(*testing.T, test)
+		find    func(test) ([]controller.Binding, error)
+		want    []string
+	}
+	tests := []test{
+		{
+			name: "Thumbprint",
+			find: func(tt test) ([]controller.Binding, error) {
+				return tt.svc.FindByRealm(crypto.Thumbprint(realmPK))
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name: "Name",
+			find: func(tt test) ([]controller.Binding, error) {
+				return tt.svc.FindByRealmName("example.net")
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "Unbound",
+			prepare: func(t *testing.T, tt test) {
+				b, _ := tt.svc.Get("c")
+				if err := b.Unbind(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			find: func(tt test) ([]controller.Binding, error) {
+				return tt.svc.FindByRealm(crypto.Thumbprint(realmPK))
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "Dont_Exist",
+			find: func(tt test) ([]controller.Binding, error) {
+				return tt.svc.FindByRealmName("example.org")
+			},
+			want: []string{},
+		},
+	}
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.svc = svc.Create(t)
+					realms := map[string]*document.RealmDescriptor{
+						"a": {Name: "example.com", PublicKey: realmPK},
+						"b": {Name: "example.net"},
+						"c": {Name: "example.com", PublicKey: realmPK},
+					}
+					for _, id := range []string{"a", "b", "c", "d"} {
+						b, err := tt.svc.New(id)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if realm, ok := realms[id]; ok {
+							if err := b.Bind(&document.ControllerBinding{RealmDescriptor: realm}); err != nil {
+								t.Fatal(err)
+							}
+						}
+					}
+					if tt.prepare != nil {
+						tt.prepare(t, tt)
+					}
+					got, err := tt.find(tt)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids := make([]string, 0)
+					for _, b := range got {
+						ids = append(ids, b.ID())
+					}
+					if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+						t.Errorf("BindingService.FindByRealm() = %v, want %v", ids, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_BindingService_Errors(t *testing.T) {
+	type test struct {
+		name    string
+		svc     controller.BindingService
+		run     func(test) error
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "Get_Not_Found",
+			run: func(tt test) error {
+				_, err := tt.svc.Get("missing")
+				return err
+			},
+			wantErr: controller.ErrNotFound,
+		},
+		{
+			name: "Delete_Not_Found",
+			run: func(tt test) error {
+				return tt.svc.Delete("missing")
+			},
+			wantErr: controller.ErrNotFound,
+		},
+		{
+			name: "New_Already_Exists",
+			run: func(tt test) error {
+				_, err := tt.svc.New("test")
+				return err
+			},
+			wantErr: controller.ErrAlreadyExists,
+		},
+		{
+			name: "Unbind_Not_Bound",
+			run: func(tt test) error {
+				b, _ := tt.svc.Get("test")
+				return b.Unbind()
+			},
+			wantErr: controller.ErrNotBound,
+		},
+	}
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.svc = svc.Create(t)
+					if _, err := tt.svc.New("test"); err != nil {
+						t.Fatal(err)
+					}
+					if err := tt.run(tt); err != tt.wantErr {
+						t.Errorf("error = %v, want %v", err, tt.wantErr)
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_BindingService_Context(t *testing.T) {
+	type test struct {
+		name    string
+		svc     controller.ContextBindingService
+		cancel  bool
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "Active",
+		},
+		{
+			name:    "Canceled",
+			cancel:  true,
+			wantErr: context.Canceled,
+		},
+	}
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					var ok bool
+					tt.svc, ok = svc.Create(t).(controller.ContextBindingService)
+					if !ok {
+						t.Skip("BindingService does not support context")
+					}
+					if _, err := tt.svc.New("existing"); err != nil {
+						t.Fatal(err)
+					}
+
+					ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+					defer cancel()
+					if tt.cancel {
+						cancel()
+					}
+
+					if _, err := tt.svc.NewContext(ctx, "test"); err != tt.wantErr {
+						t.Errorf("BindingService.NewContext() error = %v, want %v", err, tt.wantErr)
+					}
+					b, err := tt.svc.GetContext(ctx, "existing")
+					if err != tt.wantErr {
+						t.Fatalf("BindingService.GetContext() error = %v, want %v", err, tt.wantErr)
+					}
+					if _, _, err := tt.svc.ListContext(ctx, controller.ListOptions{}); err != tt.wantErr {
+						t.Errorf("BindingService.ListContext() error = %v, want %v", err, tt.wantErr)
+					}
+					if tt.wantErr == nil {
+						if err := b.(controller.ContextBinding).SetStatusContext(ctx, "ok"); err != nil {
+							t.Fatal(err)
+						}
+						b, _ = tt.svc.Get("existing")
+						if b.Status() != "ok" {
+							t.Errorf("Status = %v, want ok", b.Status())
+						}
+					}
+					if err := tt.svc.DeleteContext(ctx, "existing"); err != tt.wantErr {
+						t.Errorf("BindingService.DeleteContext() error = %v, want %v", err, tt.wantErr)
+					}
+				})
+			}
+		})
+	}
+}
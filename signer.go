@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"github.com/Brickchain/go-crypto.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// Signer performs the private key operations of a binding, so the key material does not have to
+// be handed to the code that signs or decrypts.
+type Signer interface {
+	// PublicKey returns the current public key of the binding.
+	PublicKey() *jose.JsonWebKey
+
+	// Sign signs the payload with the current binding key and returns the JWS in JSON serialization.
+	// The protected header carries the kid of the key, so a verifier can pick it during a rotation,
+	// and the extra headers.
+	Sign(payload []byte, headers map[string]interface{}) (string, error)
+
+	// Decrypt decrypts a JWE encrypted to the current or a retired binding key.
+	Decrypt(*jose.JsonWebEncryption) ([]byte, error)
+}
+
+// KeyStore returns Signers for bindings. The only KeyStore in this package is NewStoredKeyStore,
+// which keeps the private keys in a StoredKeyService. No PKCS#11 or other external key manager
+// adapter is shipped; such an adapter implements KeyStore itself and can use SignJWS so it only
+// has to provide the raw signature.
+type KeyStore interface {
+	Signer(Binding) (Signer, error)
+}
+
+// NewStoredKeyStore returns a KeyStore for keys generated by Binding.GenerateKey, kept in svc and
+// encrypted with the Key Encryption Key (KEK). The private key is only decrypted for the duration of an operation.
+func NewStoredKeyStore(svc keys.StoredKeyService, kek []byte) KeyStore {
+	return &storedKeyStore{
+		svc: svc,
+		kek: kek,
+	}
+}
+
+type storedKeyStore struct {
+	svc keys.StoredKeyService
+	kek []byte
+}
+
+func (s *storedKeyStore) Signer(b Binding) (Signer, error) {
+	if b.PublicKey() == nil {
+		return nil, ErrNoKey
+	}
+
+	return &storedKeySigner{
+		store:   s,
+		binding: b,
+	}, nil
+}
+
+type storedKeySigner struct {
+	store   *storedKeyStore
+	binding Binding
+}
+
+func (s *storedKeySigner) PublicKey() *jose.JsonWebKey {
+	return s.binding.PublicKey()
+}
+
+// privateKey decrypts the stored key with the ID
+func (s *storedKeySigner) privateKey(id string) (*jose.JsonWebKey, error) {
+	skey, err := s.store.svc.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return skey.Decrypt(s.store.kek)
+}
+
+func (s *storedKeySigner) Sign(payload []byte, headers map[string]interface{}) (string, error) {
+	key, err := s.privateKey(s.binding.ID())
+	if err != nil {
+		return "", err
+	}
+
+	alg := key.Algorithm
+	if alg == "" {
+		alg = defaultAlgorithm(key)
+	}
+
+	return SignJWS(s.binding.PublicKey(), alg, payload, headers, func(input []byte) ([]byte, error) {
+		return signWithKey(key, alg, input)
+	})
+}
+
+func (s *storedKeySigner) Decrypt(jwe *jose.JsonWebEncryption) ([]byte, error) {
+	id := s.binding.ID()
+	if kid := jwe.Header.KeyID; kid != "" {
+		for _, k := range s.binding.PublicKeys() {
+			if !k.Active() && (k.Key.KeyID == kid || crypto.Thumbprint(k.Key) == kid) {
+				id = RetiredKeyID(s.binding.ID(), k.Key)
+				break
+			}
+		}
+	}
+
+	key, err := s.privateKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwe.Decrypt(key.Key)
+}
@@ -0,0 +1,214 @@
+package controller_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+// externalKeyStore is a KeyStore that keeps the keys itself, like an external key manager would
+type externalKeyStore struct {
+	keys map[string]*jose.JsonWebKey
+}
+
+func (e *externalKeyStore) Signer(b controller.Binding) (controller.Signer, error) {
+	key, ok := e.keys[b.ID()]
+	if !ok {
+		return nil, controller.ErrNoKey
+	}
+
+	return &externalSigner{key: key}, nil
+}
+
+type externalSigner struct {
+	key *jose.JsonWebKey
+}
+
+func (e *externalSigner) PublicKey() *jose.JsonWebKey {
+	pk, _ := crypto.NewPublicKey(e.key)
+	return pk
+}
+
+func (e *externalSigner) Sign(payload []byte, headers map[string]interface{}) (string, error) {
+	return controller.SignJWS(e.PublicKey(), "ES256", payload, headers, func(input []byte) ([]byte, error) {
+		digest := sha256.Sum256(input)
+		r, s, err := ecdsa.Sign(rand.Reader, e.key.Key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		sig := make([]byte, 64)
+		copy(sig[32-len(r.Bytes()):32], r.Bytes())
+		copy(sig[64-len(s.Bytes()):], s.Bytes())
+
+		return sig, nil
+	})
+}
+
+func (e *externalSigner) Decrypt(jwe *jose.JsonWebEncryption) ([]byte, error) {
+	return jwe.Decrypt(e.key.Key)
+}
+
+// verifySigned verifies the serialized JWS with pk and returns the payload and the protected header
+func verifySigned(t *testing.T, pk *jose.JsonWebKey, serialized string) (string, map[string]interface{}) {
+	t.Helper()
+
+	jws, err := crypto.UnmarshalSignature([]byte(serialized))
+	if err != nil {
+		t.Fatalf("UnmarshalSignature() error = %v", err)
+	}
+	payload, err := jws.Verify(pk)
+	if err != nil {
+		t.Fatalf("JWS did not verify: %v", err)
+	}
+
+	var raw struct {
+		Protected string `json:"protected"`
+	}
+	if err := json.Unmarshal([]byte(serialized), &raw); err != nil {
+		t.Fatal(err)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(raw.Protected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := make(map[string]interface{})
+	if err := json.Unmarshal(b, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(payload), header
+}
+
+func encryptTo(t *testing.T, pk *jose.JsonWebKey, payload string) *jose.JsonWebEncryption {
+	t.Helper()
+
+	enc, err := jose.NewEncrypter(jose.ECDH_ES_A256KW, jose.A256GCM, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enc.Encrypt([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwe, err := jose.ParseEncrypted(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jwe
+}
+
+func Test_Binding_Signer(t *testing.T) {
+	for _, svc := range services {
+		t.Run(svc.Name, func(t *testing.T) {
+			bsvc := svc.Create(t)
+			ksvc := keys.NewMockStoredKeyService()
+			kek := crypto.NewSymmetricKey(jose.A256KW)
+			store := controller.NewStoredKeyStore(ksvc, kek)
+
+			b, err := bsvc.New("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.Signer(store); err != controller.ErrNoKey {
+				t.Errorf("Binding.Signer() without key error = %v, want %v", err, controller.ErrNoKey)
+			}
+
+			if err := b.GenerateKey(ksvc, kek); err != nil {
+				t.Fatal(err)
+			}
+			b, _ = bsvc.Get("test")
+			old := b.PublicKey()
+			oldJWE := encryptTo(t, old, "to the old key")
+
+			if err := b.GenerateKey(ksvc, kek); err != nil {
+				t.Fatal(err)
+			}
+			b, _ = bsvc.Get("test")
+
+			signer, err := b.Signer(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crypto.Thumbprint(signer.PublicKey()) != crypto.Thumbprint(b.PublicKey()) {
+				t.Error("Signer.PublicKey() is not the current binding key")
+			}
+
+			jws, err := signer.Sign([]byte("payload"), map[string]interface{}{"typ": "test", "kid": "other"})
+			if err != nil {
+				t.Fatalf("Signer.Sign() error = %v", err)
+			}
+			payload, header := verifySigned(t, b.PublicKey(), jws)
+			if payload != "payload" {
+				t.Errorf("Signer.Sign() payload = %s, want payload", payload)
+			}
+			if header["kid"] != crypto.Thumbprint(b.PublicKey()) {
+				t.Errorf("Signer.Sign() kid = %v, want %s", header["kid"], crypto.Thumbprint(b.PublicKey()))
+			}
+			if header["kid"] == crypto.Thumbprint(old) {
+				t.Error("Signer.Sign() kid is the retired key")
+			}
+			if header["typ"] != "test" {
+				t.Errorf("Signer.Sign() typ = %v, want test", header["typ"])
+			}
+
+			tests := []struct {
+				name string
+				jwe  *jose.JsonWebEncryption
+				want string
+			}{
+				{"Current", encryptTo(t, b.PublicKey(), "to the current key"), "to the current key"},
+				{"Retired", oldJWE, "to the old key"},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					got, err := signer.Decrypt(tt.jwe)
+					if err != nil {
+						t.Fatalf("Signer.Decrypt() error = %v", err)
+					}
+					if string(got) != tt.want {
+						t.Errorf("Signer.Decrypt() = %s, want %s", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func Test_Binding_Signer_External(t *testing.T) {
+	key, err := crypto.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &externalKeyStore{keys: map[string]*jose.JsonWebKey{"test": key}}
+
+	b, err := controller.NewMemoryBindingService().New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := b.Signer(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign([]byte("payload"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, header := verifySigned(t, signer.PublicKey(), jws); header["kid"] != crypto.Thumbprint(signer.PublicKey()) {
+		t.Errorf("Signer.Sign() kid = %v, want %s", header["kid"], crypto.Thumbprint(signer.PublicKey()))
+	}
+}
@@ -0,0 +1,351 @@
+package sqldb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+	keys "github.com/Brickchain/go-keys.v1"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+type sqlBinding struct {
+	svc            *sqlBindingService
+	DBid           string
+	DBsecret       string
+	DBprevSecret   string
+	DBprevExpires  int64
+	DBpublicKey    string
+	DBkeyCreated   int64
+	DBretiredKeys  string
+	DBdescriptor   string
+	DBbinding      string
+	DBstatus       string
+	DBbindEndpoint string
+	DBrealmName    string
+	DBrealmKeyTP   string
+	secret         string
+}
+
+// exec runs an UPDATE statement for this binding, the id is appended to args
+func (b *sqlBinding) exec(ctx context.Context, query string, args ...interface{}) error {
+	res, err := b.svc.db.ExecContext(ctx, b.svc.dialect.rebind(query), append(args, b.DBid)...)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// ID returns the ID of the binding.
+func (b *sqlBinding) ID() string {
+	return b.DBid
+}
+
+// Secret for the binding.
+func (b *sqlBinding) Secret() string {
+	return b.secret
+}
+
+// VerifySecret reports if the secret matches the binding secret, in constant time.
+func (b *sqlBinding) VerifySecret(secret string) bool {
+	return controller.VerifyRotatedSecret(b.DBsecret, b.DBprevSecret, time.Unix(0, b.DBprevExpires), secret)
+}
+
+// RotateSecret replaces the binding secret, the previous secret is accepted for the grace period.
+func (b *sqlBinding) RotateSecret(grace time.Duration) (string, error) {
+	return b.RotateSecretContext(context.Background(), grace)
+}
+
+// RotateSecretContext is like RotateSecret but with a context.
+func (b *sqlBinding) RotateSecretContext(ctx context.Context, grace time.Duration) (string, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(grace).UnixNano()
+	if err = b.exec(ctx, "UPDATE bindings SET previous_secret = secret, previous_secret_expires = ?, secret = ? WHERE id = ?", expires, hash); err != nil {
+		return "", err
+	}
+	b.DBprevSecret = b.DBsecret
+	b.DBprevExpires = expires
+	b.DBsecret = hash
+	b.secret = secret
+
+	return secret, nil
+}
+
+// GenerateKey will generate a new keypair for this binding.
+func (b *sqlBinding) GenerateKey(svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyContext(context.Background(), svc, kek)
+}
+
+// GenerateKeyContext is like GenerateKey but with a context.
+func (b *sqlBinding) GenerateKeyContext(ctx context.Context, svc keys.StoredKeyService, kek []byte) error {
+	return b.GenerateKeyWithOptionsContext(ctx, svc, kek, controller.KeyOptions{})
+}
+
+// GenerateKeyWithOptions is like GenerateKey but generates the kind of key selected by the KeyOptions.
+func (b *sqlBinding) GenerateKeyWithOptions(svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return b.GenerateKeyWithOptionsContext(context.Background(), svc, kek, opts)
+}
+
+// GenerateKeyWithOptionsContext is like GenerateKeyWithOptions but with a context.
+func (b *sqlBinding) GenerateKeyWithOptionsContext(ctx context.Context, svc keys.StoredKeyService, kek []byte, opts controller.KeyOptions) error {
+	return controller.ReplaceKey(svc, b.DBid, kek, opts, b.currentKey(), b.retiredKeys(), func(k controller.BindingKey, retired []controller.BindingKey) error {
+		bytes, err := json.Marshal(k.Key)
+		if err != nil {
+			return err
+		}
+
+		retiredBytes, err := json.Marshal(retired)
+		if err != nil {
+			return err
+		}
+
+		err = b.exec(ctx, "UPDATE bindings SET public_key = ?, key_created = ?, retired_keys = ? WHERE id = ?", string(bytes), k.Created.UnixNano(), string(retiredBytes))
+		if err != nil {
+			return err
+		}
+		b.DBpublicKey = string(bytes)
+		b.DBkeyCreated = k.Created.UnixNano()
+		b.DBretiredKeys = string(retiredBytes)
+
+		return nil
+	})
+}
+
+// PublicKey of the binding.
+func (b *sqlBinding) PublicKey() *jose.JsonWebKey {
+	if b.DBpublicKey == "" {
+		return nil
+	}
+
+	key, _ := crypto.UnmarshalKey([]byte(b.DBpublicKey))
+	return key
+}
+
+// PublicKeys returns the current key followed by the retired keys, newest first.
+func (b *sqlBinding) PublicKeys() []controller.BindingKey {
+	return controller.KeySet(b.currentKey(), b.retiredKeys())
+}
+
+func (b *sqlBinding) currentKey() controller.BindingKey {
+	if b.DBpublicKey == "" {
+		return controller.BindingKey{}
+	}
+
+	return controller.BindingKey{
+		Key:     b.PublicKey(),
+		Created: time.Unix(0, b.DBkeyCreated),
+	}
+}
+
+func (b *sqlBinding) retiredKeys() []controller.BindingKey {
+	retired := make([]controller.BindingKey, 0)
+	if b.DBretiredKeys != "" {
+		json.Unmarshal([]byte(b.DBretiredKeys), &retired)
+	}
+
+	return retired
+}
+
+// PrivateKey of the binding. Requires a StoredKeyService and a Key Encryption Key (KEK).
+func (b *sqlBinding) PrivateKey(svc keys.StoredKeyService, kek []byte) (*jose.JsonWebKey, error) {
+	skey, err := svc.Get(b.DBid)
+	if err != nil {
+		return nil, err
+	}
+
+	return skey.Decrypt(kek)
+}
+
+// Signer returns a Signer for the private key operations of the binding from the KeyStore.
+func (b *sqlBinding) Signer(ks controller.KeyStore) (controller.Signer, error) {
+	return ks.Signer(b)
+}
+
+// Descriptor returns the ControllerDescriptor for this binding
+func (b *sqlBinding) Descriptor() document.ControllerDescriptor {
+	desc := document.ControllerDescriptor{}
+	json.Unmarshal([]byte(b.DBdescriptor), &desc)
+
+	return desc
+}
+
+// SetDescriptor sets the ControllerDescriptor for this binding
+func (b *sqlBinding) SetDescriptor(desc document.ControllerDescriptor) error {
+	return b.SetDescriptorContext(context.Background(), desc)
+}
+
+// SetDescriptorContext is like SetDescriptor but with a context.
+func (b *sqlBinding) SetDescriptorContext(ctx context.Context, desc document.ControllerDescriptor) error {
+	bytes, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+
+	if err = b.exec(ctx, "UPDATE bindings SET descriptor = ? WHERE id = ?", string(bytes)); err != nil {
+		return err
+	}
+	b.DBdescriptor = string(bytes)
+
+	return nil
+}
+
+// Bind is used when the realm binds to this binding.
+func (b *sqlBinding) Bind(c *document.ControllerBinding) error {
+	return b.BindContext(context.Background(), c)
+}
+
+// BindContext is like Bind but with a context.
+func (b *sqlBinding) BindContext(ctx context.Context, c *document.ControllerBinding) error {
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	realmName, realmTP := "", ""
+	if c.RealmDescriptor != nil {
+		realmName = c.RealmDescriptor.Name
+		if c.RealmDescriptor.PublicKey != nil {
+			realmTP = crypto.Thumbprint(c.RealmDescriptor.PublicKey)
+		}
+	}
+
+	if err = b.exec(ctx, "UPDATE bindings SET binding = ?, realm_name = ?, realm_thumbprint = ? WHERE id = ?", string(bytes), realmName, realmTP); err != nil {
+		return err
+	}
+	b.DBbinding = string(bytes)
+	b.DBrealmName = realmName
+	b.DBrealmKeyTP = realmTP
+
+	if b.svc.postBindFunc != nil {
+		b.svc.postBindFunc(b)
+	}
+
+	return nil
+}
+
+// Unbind removes the realm binding
+func (b *sqlBinding) Unbind() error {
+	return b.UnbindContext(context.Background())
+}
+
+// UnbindContext is like Unbind but with a context.
+func (b *sqlBinding) UnbindContext(ctx context.Context) error {
+	if b.DBbinding == "" {
+		return controller.ErrNotBound
+	}
+
+	if err := b.exec(ctx, "UPDATE bindings SET binding = '', realm_name = '', realm_thumbprint = '' WHERE id = ?"); err != nil {
+		return err
+	}
+	b.DBbinding = ""
+	b.DBrealmName = ""
+	b.DBrealmKeyTP = ""
+
+	if b.svc.postUnbindFunc != nil {
+		b.svc.postUnbindFunc(b)
+	}
+
+	return nil
+}
+
+// Certificate of the binding.
+func (b *sqlBinding) Certificate() string {
+	c := b.ControllerBinding()
+	if c == nil {
+		return ""
+	}
+
+	return c.ControllerCertificate
+}
+
+// Mandates returns the mandates we got from the realm.
+func (b *sqlBinding) Mandates() []string {
+	c := b.ControllerBinding()
+	if c == nil || c.Mandates == nil {
+		return []string{}
+	}
+
+	return c.Mandates
+}
+
+// AdminRoles returns the list of roles that can administer this binding.
+func (b *sqlBinding) AdminRoles() []string {
+	c := b.ControllerBinding()
+	if c == nil || c.AdminRoles == nil {
+		return []string{}
+	}
+
+	return c.AdminRoles
+}
+
+// Realm returns the realm-descriptor that was used for this binding.
+func (b *sqlBinding) Realm() *document.RealmDescriptor {
+	c := b.ControllerBinding()
+	if c == nil {
+		return nil
+	}
+
+	return c.RealmDescriptor
+}
+
+// ControllerBinding returns the controller-binding document.
+func (b *sqlBinding) ControllerBinding() *document.ControllerBinding {
+	if b.DBbinding == "" {
+		return nil
+	}
+
+	c := &document.ControllerBinding{}
+	if err := json.Unmarshal([]byte(b.DBbinding), &c); err != nil {
+		return nil
+	}
+
+	return c
+}
+
+// Status is used to tell the realm if this binding requires some extra setup steps.
+func (b *sqlBinding) Status() string {
+	return b.DBstatus
+}
+
+// SetStatus updates the Status value.
+func (b *sqlBinding) SetStatus(v string) error {
+	return b.SetStatusContext(context.Background(), v)
+}
+
+// SetStatusContext is like SetStatus but with a context.
+func (b *sqlBinding) SetStatusContext(ctx context.Context, v string) error {
+	if err := b.exec(ctx, "UPDATE bindings SET status = ? WHERE id = ?", v); err != nil {
+		return err
+	}
+	b.DBstatus = v
+
+	return nil
+}
+
+// BindEndpoint returns the endpoint where the realm should post the controller-binding.
+func (b *sqlBinding) BindEndpoint() string {
+	return b.DBbindEndpoint
+}
+
+// SetBindEndpoint updates the BindEndpoint value.
+func (b *sqlBinding) SetBindEndpoint(v string) error {
+	return b.SetBindEndpointContext(context.Background(), v)
+}
+
+// SetBindEndpointContext is like SetBindEndpoint but with a context.
+func (b *sqlBinding) SetBindEndpointContext(ctx context.Context, v string) error {
+	if err := b.exec(ctx, "UPDATE bindings SET bind_endpoint = ? WHERE id = ?", v); err != nil {
+		return err
+	}
+	b.DBbindEndpoint = v
+
+	return nil
+}
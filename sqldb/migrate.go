@@ -0,0 +1,192 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a versioned set of schema changes
+type migration struct {
+	version    int
+	statements []string
+}
+
+// migrations are applied in order and must never be changed once released, add a new version instead
+var migrations = []migration{
+	{
+		version: 1,
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS bindings (
+				id VARCHAR(255) NOT NULL PRIMARY KEY,
+				secret TEXT NOT NULL DEFAULT '',
+				public_key TEXT NOT NULL DEFAULT '',
+				descriptor TEXT NOT NULL DEFAULT '',
+				binding TEXT NOT NULL DEFAULT '',
+				status VARCHAR(255) NOT NULL DEFAULT '',
+				bind_endpoint TEXT NOT NULL DEFAULT '',
+				realm_name VARCHAR(255) NOT NULL DEFAULT '',
+				realm_thumbprint VARCHAR(255) NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_bindings_status ON bindings (status)`,
+			`CREATE INDEX IF NOT EXISTS idx_bindings_realm_name ON bindings (realm_name)`,
+			`CREATE INDEX IF NOT EXISTS idx_bindings_realm_thumbprint ON bindings (realm_thumbprint)`,
+		},
+	},
+	{
+		version: 2,
+		statements: []string{
+			`ALTER TABLE bindings ADD COLUMN previous_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE bindings ADD COLUMN previous_secret_expires BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 3,
+		statements: []string{
+			`ALTER TABLE bindings ADD COLUMN key_created BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE bindings ADD COLUMN retired_keys TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// SchemaVersion returns the latest schema version known by this package
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrationLock is the PostgreSQL advisory lock key held while migrating
+const migrationLock = 7427361150872141
+
+// Migrate applies all schema migrations that have not yet been applied to the database.
+// Each migration runs in its own transaction and is recorded in the schema_migrations table.
+// The schema version is read and the migration applied under a lock, so services starting at the
+// same time do not apply the same migration twice: pg_advisory_xact_lock on PostgreSQL and
+// BEGIN IMMEDIATE on SQLite, where the driver should be given a busy timeout to wait for the lock.
+// The PostgreSQL dialect is not covered by the tests of this package, which only run against SQLite.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	for {
+		applied, err := migrateNext(ctx, db, dialect)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			return nil
+		}
+	}
+}
+
+// migrateNext applies the first migration that has not been applied yet and reports if there was one
+func migrateNext(ctx context.Context, db *sql.DB, dialect Dialect) (bool, error) {
+	tx, err := beginLocked(ctx, db, dialect)
+	if err != nil {
+		return false, err
+	}
+
+	m, err := nextMigration(ctx, tx, dialect)
+	if err != nil || m == nil {
+		if rerr := tx.rollback(); err == nil {
+			err = rerr
+		}
+		return false, err
+	}
+
+	for _, stmt := range m.statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.rollback()
+			return false, fmt.Errorf("failed to apply schema migration %d: %s", m.version, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, dialect.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), m.version, time.Now().UTC()); err != nil {
+		tx.rollback()
+		return false, fmt.Errorf("failed to apply schema migration %d: %s", m.version, err)
+	}
+
+	if err := tx.commit(); err != nil {
+		return false, fmt.Errorf("failed to apply schema migration %d: %s", m.version, err)
+	}
+
+	return true, nil
+}
+
+// nextMigration returns the first migration newer than the schema version, or nil when the schema is up to date
+func nextMigration(ctx context.Context, q querier, dialect Dialect) (*migration, error) {
+	if _, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return nil, err
+	}
+
+	current, err := currentVersion(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		if migrations[i].version > current {
+			return &migrations[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// querier is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func currentVersion(ctx context.Context, q querier) (int, error) {
+	var version sql.NullInt64
+	if err := q.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// lockedTx is a transaction that holds the migration lock until it ends
+type lockedTx struct {
+	querier
+	commit   func() error
+	rollback func() error
+}
+
+// beginLocked starts a transaction holding the migration lock of the dialect
+func beginLocked(ctx context.Context, db *sql.DB, dialect Dialect) (*lockedTx, error) {
+	if dialect == Postgres {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		return &lockedTx{querier: tx, commit: tx.Commit, rollback: tx.Rollback}, nil
+	}
+
+	// database/sql can not start an IMMEDIATE transaction, so it is run on a dedicated connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	end := func(stmt string) func() error {
+		return func() error {
+			defer conn.Close()
+			_, err := conn.ExecContext(context.Background(), stmt)
+			return err
+		}
+	}
+
+	return &lockedTx{querier: conn, commit: end("COMMIT"), rollback: end("ROLLBACK")}, nil
+}
@@ -0,0 +1,95 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func Test_Dialect_rebind(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		query   string
+		want    string
+	}{
+		{SQLite, "UPDATE bindings SET status = ? WHERE id = ?", "UPDATE bindings SET status = ? WHERE id = ?"},
+		{Postgres, "UPDATE bindings SET status = ? WHERE id = ?", "UPDATE bindings SET status = $1 WHERE id = $2"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			if got := tt.dialect.rebind(tt.query); got != tt.want {
+				t.Errorf("Dialect.rebind() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(context.Background(), db, SQLite); err != nil {
+			t.Fatalf("Migrate() run %d error = %v", i, err)
+		}
+	}
+
+	version, err := currentVersion(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != SchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, SchemaVersion())
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", count, len(migrations))
+	}
+}
+
+func Test_Migrate_Concurrent(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	const workers = 4
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Migrate(context.Background(), db, SQLite)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Migrate() error = %v", err)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", count, len(migrations))
+	}
+}
@@ -0,0 +1,261 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	controller "github.com/Brickchain/go-controller.v2"
+)
+
+// Dialect selects the SQL flavour used for the database
+type Dialect string
+
+const (
+	// Postgres is the dialect for PostgreSQL. The tests of this package run against SQLite only,
+	// the statements are not run against a PostgreSQL server.
+	Postgres Dialect = "postgres"
+
+	// SQLite is the dialect for SQLite
+	SQLite Dialect = "sqlite3"
+)
+
+// rebind rewrites the ? placeholders in query to the format used by the dialect
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+const bindingColumns = "id, secret, previous_secret, previous_secret_expires, public_key, key_created, retired_keys, descriptor, binding, status, bind_endpoint, realm_name, realm_thumbprint"
+
+type sqlBindingService struct {
+	db             *sql.DB
+	dialect        Dialect
+	postBindFunc   func(controller.Binding)
+	postUnbindFunc func(controller.Binding)
+}
+
+// New returns a BindingService that stores the bindings using database/sql.
+// The schema migrations are applied before the service is returned.
+func New(db *sql.DB, dialect Dialect) (controller.ContextBindingService, error) {
+	if err := Migrate(context.Background(), db, dialect); err != nil {
+		return nil, err
+	}
+
+	return &sqlBindingService{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+func (s *sqlBindingService) SetPostBind(f func(controller.Binding)) {
+	s.postBindFunc = f
+}
+
+func (s *sqlBindingService) PostBind() func(controller.Binding) {
+	return s.postBindFunc
+}
+
+func (s *sqlBindingService) SetPostUnbind(f func(controller.Binding)) {
+	s.postUnbindFunc = f
+}
+
+func (s *sqlBindingService) PostUnbind() func(controller.Binding) {
+	return s.postUnbindFunc
+}
+
+func (s *sqlBindingService) New(id string) (controller.Binding, error) {
+	return s.NewContext(context.Background(), id)
+}
+
+func (s *sqlBindingService) NewContext(ctx context.Context, id string) (controller.Binding, error) {
+	secret, hash, err := controller.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &sqlBinding{
+		svc:      s,
+		DBid:     id,
+		DBsecret: hash,
+		secret:   secret,
+	}
+
+	// the insert is skipped if the ID is taken, so concurrent calls can not race between a lookup and the insert
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind("INSERT INTO bindings (id, secret) VALUES (?, ?) ON CONFLICT (id) DO NOTHING"), id, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		existing, err := s.GetContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return existing, controller.ErrAlreadyExists
+	}
+
+	return b, nil
+}
+
+func (s *sqlBindingService) Get(id string) (controller.Binding, error) {
+	return s.GetContext(context.Background(), id)
+}
+
+func (s *sqlBindingService) GetContext(ctx context.Context, id string) (controller.Binding, error) {
+	row := s.db.QueryRowContext(ctx, s.dialect.rebind("SELECT "+bindingColumns+" FROM bindings WHERE id = ?"), id)
+
+	b, err := s.scan(row)
+	if err == sql.ErrNoRows {
+		return nil, controller.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *sqlBindingService) Delete(id string) error {
+	return s.DeleteContext(context.Background(), id)
+}
+
+func (s *sqlBindingService) DeleteContext(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind("DELETE FROM bindings WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (s *sqlBindingService) List(opts controller.ListOptions) ([]controller.Binding, string, error) {
+	return s.ListContext(context.Background(), opts)
+}
+
+func (s *sqlBindingService) ListContext(ctx context.Context, opts controller.ListOptions) ([]controller.Binding, string, error) {
+	where := []string{"id > ?"}
+	args := []interface{}{opts.Cursor}
+
+	if opts.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, opts.Status)
+	}
+
+	if opts.Bound != nil {
+		if *opts.Bound {
+			where = append(where, "binding <> ''")
+		} else {
+			where = append(where, "binding = ''")
+		}
+	}
+
+	if opts.Realm != "" {
+		where = append(where, "realm_name = ?")
+		args = append(args, opts.Realm)
+	}
+
+	if opts.RealmThumbprint != "" {
+		where = append(where, "realm_thumbprint = ?")
+		args = append(args, opts.RealmThumbprint)
+	}
+
+	query := "SELECT " + bindingColumns + " FROM bindings WHERE " + strings.Join(where, " AND ") + " ORDER BY id"
+	if opts.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(opts.Limit+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	bindings := make([]controller.Binding, 0)
+	for rows.Next() {
+		b, err := s.scan(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		bindings = append(bindings, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	cursor := ""
+	if opts.Limit > 0 && len(bindings) > opts.Limit {
+		bindings = bindings[:opts.Limit]
+		cursor = bindings[len(bindings)-1].ID()
+	}
+
+	return bindings, cursor, nil
+}
+
+func (s *sqlBindingService) FindByRealm(thumbprint string) ([]controller.Binding, error) {
+	return s.FindByRealmContext(context.Background(), thumbprint)
+}
+
+func (s *sqlBindingService) FindByRealmContext(ctx context.Context, thumbprint string) ([]controller.Binding, error) {
+	bindings, _, err := s.ListContext(ctx, controller.ListOptions{RealmThumbprint: thumbprint})
+	return bindings, err
+}
+
+func (s *sqlBindingService) FindByRealmName(name string) ([]controller.Binding, error) {
+	return s.FindByRealmNameContext(context.Background(), name)
+}
+
+func (s *sqlBindingService) FindByRealmNameContext(ctx context.Context, name string) ([]controller.Binding, error) {
+	bindings, _, err := s.ListContext(ctx, controller.ListOptions{Realm: name})
+	return bindings, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *sqlBindingService) scan(row scanner) (*sqlBinding, error) {
+	b := &sqlBinding{
+		svc: s,
+	}
+
+	err := row.Scan(&b.DBid, &b.DBsecret, &b.DBprevSecret, &b.DBprevExpires, &b.DBpublicKey, &b.DBkeyCreated, &b.DBretiredKeys, &b.DBdescriptor, &b.DBbinding, &b.DBstatus, &b.DBbindEndpoint, &b.DBrealmName, &b.DBrealmKeyTP)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// checkAffected returns ErrNotFound if the statement did not change any rows
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return controller.ErrNotFound
+	}
+
+	return nil
+}
@@ -1,87 +1,382 @@
 package controller
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Brickchain/go-crypto.v2"
 	"github.com/Brickchain/go-document.v2"
+	"github.com/pkg/errors"
 	jose "gopkg.in/square/go-jose.v1"
 )
 
-// VerifyMandateToken is used to verify that a mandate-token is correctly signed
+// VerifyOptions controls the checks of VerifyMandateTokenWithOptions.
+type VerifyOptions struct {
+	// Clock returns the time the token and mandates are checked against, defaults to time.Now.
+	Clock func() time.Time
+
+	// Skew is how far the clocks of the realm and the client may drift from Clock.
+	// The TTL of the token and the validity period of the mandates are extended by Skew.
+	Skew time.Duration
+
+	// ReplayCache enables replay protection: a token is rejected if it was already accepted within its TTL.
+	// Tokens are identified by their @id, or by a hash of their payload if they have none.
+	ReplayCache ReplayCache
+
+	// MaxTTL is the longest a token may remain valid, tokens with a longer TTL or a timestamp in the
+	// future that extends it beyond MaxTTL are rejected. It bounds how long the ReplayCache holds a token,
+	// so it defaults to DefaultMaxTokenTTL when a ReplayCache is set and is not checked otherwise.
+	MaxTTL time.Duration
+
+	// Audience enables audience checks: the URI of the token has to be one of the audiences, such as the
+	// endpoint or binding ID of the controller, or an URL below one of them. VerifyBindingToken adds the binding ID.
+	Audience []string
+}
+
+// DefaultMaxTokenTTL is the MaxTTL of VerifyOptions with a ReplayCache.
+const DefaultMaxTokenTTL = 5 * time.Minute
+
+// maxTTL returns the MaxTTL, or DefaultMaxTokenTTL when it is not set and a ReplayCache is
+func (o VerifyOptions) maxTTL() time.Duration {
+	if o.MaxTTL == 0 && o.ReplayCache != nil {
+		return DefaultMaxTokenTTL
+	}
+
+	return o.MaxTTL
+}
+
+// now returns the current time from the Clock
+func (o VerifyOptions) now() time.Time {
+	if o.Clock != nil {
+		return o.Clock().UTC()
+	}
+
+	return time.Now().UTC()
+}
+
+// VerifyReason tells why a mandate-token was rejected. A VerifyReason can be used as the target
+// of errors.Is to check the reason of a *VerifyError.
+type VerifyReason int
+
+const (
+	// VerifyMalformed is used when the token or a mandate can not be parsed.
+	VerifyMalformed VerifyReason = iota + 1
+	// VerifyNoSigner is used when the token or a mandate has no signature.
+	VerifyNoSigner
+	// VerifyInvalidSignature is used when the signature of the token or a mandate does not verify.
+	VerifyInvalidSignature
+	// VerifyInvalidCertificate is used when the certificate chain of the token does not verify.
+	VerifyInvalidCertificate
+	// VerifyWrongSigner is used when a mandate is not signed by the expected key.
+	VerifyWrongSigner
+	// VerifyTokenExpired is used when the TTL of the token has passed.
+	VerifyTokenExpired
+	// VerifyMandateNotYetValid is used when the ValidFrom of a mandate has not been reached.
+	VerifyMandateNotYetValid
+	// VerifyMandateExpired is used when the ValidUntil of a mandate has passed.
+	VerifyMandateExpired
+	// VerifyReplayed is used when the token was already accepted, see VerifyOptions.ReplayCache.
+	VerifyReplayed
+	// VerifyWrongAudience is used when the URI of the token is not one of VerifyOptions.Audience.
+	VerifyWrongAudience
+	// VerifyTokenTTLTooLong is used when the token remains valid for longer than VerifyOptions.MaxTTL.
+	VerifyTokenTTLTooLong
+)
+
+var verifyReasons = map[VerifyReason]string{
+	VerifyMalformed:          "Malformed",
+	VerifyNoSigner:           "No signers",
+	VerifyInvalidSignature:   "Invalid signature",
+	VerifyInvalidCertificate: "Invalid certificate",
+	VerifyWrongSigner:        "Not signed by correct key",
+	VerifyTokenExpired:       "Token has expired",
+	VerifyMandateNotYetValid: "Mandate not yet valid",
+	VerifyMandateExpired:     "Mandate has expired",
+	VerifyReplayed:           "Token has already been used",
+	VerifyWrongAudience:      "Token is not meant for this controller",
+	VerifyTokenTTLTooLong:    "Token TTL is too long",
+}
+
+func (r VerifyReason) String() string {
+	if s, ok := verifyReasons[r]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("VerifyReason(%d)", int(r))
+}
+
+func (r VerifyReason) Error() string {
+	return r.String()
+}
+
+// Expired reports if the token or mandate was rejected for its time limits rather than for
+// being forged or malformed, so the client can be asked to get a new token.
+func (r VerifyReason) Expired() bool {
+	return r == VerifyTokenExpired || r == VerifyMandateNotYetValid || r == VerifyMandateExpired
+}
+
+// VerifyError is returned by VerifyMandateToken when a mandate-token is rejected.
+type VerifyError struct {
+	// Reason tells why the token was rejected.
+	Reason VerifyReason
+
+	// Mandate is the index of the offending mandate in the token, or -1 if the token itself was rejected.
+	Mandate int
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	msg := e.Reason.String()
+	if e.Mandate >= 0 {
+		msg = fmt.Sprintf("mandate %d: %s", e.Mandate, msg)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports if the target is the VerifyReason of the error.
+func (e *VerifyError) Is(target error) bool {
+	r, ok := target.(VerifyReason)
+	return ok && r == e.Reason
+}
+
+// tokenError returns a VerifyError for the token itself
+func tokenError(reason VerifyReason, err error) error {
+	return &VerifyError{Reason: reason, Mandate: -1, Err: err}
+}
+
+// mandateError returns a VerifyError for the mandate at index i
+func mandateError(i int, reason VerifyReason, err error) error {
+	return &VerifyError{Reason: reason, Mandate: i, Err: err}
+}
+
+// VerifyMandateToken is used to verify that a mandate-token is correctly signed.
+// Errors from the verification are of type *VerifyError.
 func VerifyMandateToken(token string, mandateSigner *jose.JsonWebKey, keyLevel int) ([]*document.Mandate, *jose.JsonWebKey, error) {
+	return VerifyMandateTokenWithOptions(token, mandateSigner, keyLevel, VerifyOptions{})
+}
+
+// VerifyMandateTokenWithOptions is used to verify that a mandate-token is correctly signed,
+// checking the token TTL and the validity of the mandates against the clock in the options,
+// and the audience and replay of the token when enabled.
+func VerifyMandateTokenWithOptions(token string, mandateSigner *jose.JsonWebKey, keyLevel int, opts VerifyOptions) ([]*document.Mandate, *jose.JsonWebKey, error) {
+	now := opts.now()
+
 	tokenJWS, err := crypto.UnmarshalSignature([]byte(token))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, tokenError(VerifyMalformed, err)
 	}
 
 	if len(tokenJWS.Signatures) < 1 {
-		return nil, nil, fmt.Errorf("No signers of token")
+		return nil, nil, tokenError(VerifyNoSigner, nil)
 	}
 
 	clientKey := tokenJWS.Signatures[0].Header.JsonWebKey
 
 	tokenPayload, err := tokenJWS.Verify(clientKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, tokenError(VerifyInvalidSignature, err)
 	}
 
 	var mandateToken *document.MandateToken
 	err = json.Unmarshal(tokenPayload, &mandateToken)
-	if err != nil {
-		return nil, nil, err
+	if err != nil || mandateToken == nil {
+		return nil, nil, tokenError(VerifyMalformed, err)
+	}
+
+	expires := mandateToken.Timestamp.Add(time.Second * time.Duration(mandateToken.TTL)).Add(opts.Skew)
+	if expires.Before(now) {
+		return nil, nil, tokenError(VerifyTokenExpired, nil)
 	}
 
-	if mandateToken.Timestamp.Add(time.Second * time.Duration(mandateToken.TTL)).Before(time.Now().UTC()) {
-		return nil, nil, fmt.Errorf("Token has expired")
+	if max := opts.maxTTL(); max > 0 && expires.After(now.Add(max).Add(opts.Skew)) {
+		return nil, nil, tokenError(VerifyTokenTTLTooLong, fmt.Errorf("valid until %s", expires))
+	}
+
+	if len(opts.Audience) > 0 && !audienceMatches(mandateToken.URI, opts.Audience) {
+		return nil, nil, tokenError(VerifyWrongAudience, fmt.Errorf("uri %q", mandateToken.URI))
 	}
 
 	if mandateToken.Certificate != "" {
 		certChain, err := crypto.VerifyCertificate(mandateToken.Certificate, keyLevel)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, tokenError(VerifyInvalidCertificate, err)
 		}
 
 		clientKey = certChain.Issuer
 	}
 
 	mandates := make([]*document.Mandate, 0)
-	for _, mandateString := range mandateToken.Mandates {
+	for i, mandateString := range mandateToken.Mandates {
 		mandateJWS, err := crypto.UnmarshalSignature([]byte(mandateString))
 		if err != nil {
-			return mandates, nil, err
+			return mandates, nil, mandateError(i, VerifyMalformed, err)
 		}
 
 		if len(mandateJWS.Signatures) < 1 {
-			return mandates, nil, fmt.Errorf("No signers of mandate")
+			return mandates, nil, mandateError(i, VerifyNoSigner, nil)
 		}
 
 		if crypto.Thumbprint(mandateJWS.Signatures[0].Header.JsonWebKey) != crypto.Thumbprint(mandateSigner) {
-			return mandates, nil, fmt.Errorf("Mandate not signed by correct key")
+			return mandates, nil, mandateError(i, VerifyWrongSigner, nil)
 		}
 
 		mandatePayload, err := mandateJWS.Verify(mandateSigner)
 		if err != nil {
-			return mandates, nil, err
+			return mandates, nil, mandateError(i, VerifyInvalidSignature, err)
 		}
 
 		var mandate *document.Mandate
-		if err := json.Unmarshal(mandatePayload, &mandate); err != nil {
-			return mandates, nil, err
+		if err := json.Unmarshal(mandatePayload, &mandate); err != nil || mandate == nil {
+			return mandates, nil, mandateError(i, VerifyMalformed, err)
 		}
 
-		if mandate.ValidFrom.Before(time.Now().UTC()) {
-			return mandates, nil, fmt.Errorf("Mandate not yet valid")
+		if !mandate.ValidFrom.IsZero() && mandate.ValidFrom.After(now.Add(opts.Skew)) {
+			return mandates, nil, mandateError(i, VerifyMandateNotYetValid, nil)
 		}
 
-		if mandate.ValidUntil.After(time.Now().UTC()) {
-			return mandates, nil, fmt.Errorf("Mandate has expired")
+		if !mandate.ValidUntil.IsZero() && mandate.ValidUntil.Before(now.Add(-opts.Skew)) {
+			return mandates, nil, mandateError(i, VerifyMandateExpired, nil)
 		}
 
 		mandates = append(mandates, mandate)
 	}
 
-	return mandates, clientKey, err
+	if opts.ReplayCache != nil {
+		seen, err := opts.ReplayCache.Seen(tokenID(tokenJWS.Signatures[0].Header.JsonWebKey, tokenPayload, mandateToken), now, expires)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to check token for replay")
+		}
+		if seen {
+			return nil, nil, tokenError(VerifyReplayed, nil)
+		}
+	}
+
+	return mandates, clientKey, nil
+}
+
+// audienceMatches reports if the uri is one of the audiences or below one of them
+func audienceMatches(uri string, audience []string) bool {
+	if uri == "" {
+		return false
+	}
+
+	for _, a := range audience {
+		if uri == a {
+			return true
+		}
+		if a != "" && strings.HasPrefix(uri, a) && (strings.HasSuffix(a, "/") || strings.ContainsAny(uri[len(a):len(a)+1], "/?#")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenID returns the ID of the token for the ReplayCache, the @id or else a hash of the verified payload,
+// scoped to the signer of the token. The signature is not used, since an ECDSA signature can be altered
+// and still verify.
+func tokenID(signer *jose.JsonWebKey, payload []byte, token *document.MandateToken) string {
+	if token.ID != "" {
+		return crypto.Thumbprint(signer) + "/" + token.ID
+	}
+
+	sum := sha256.Sum256(payload)
+	return crypto.Thumbprint(signer) + "/sha256:" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifiedMandate is a mandate from a mandate-token verified by VerifyBindingToken.
+type VerifiedMandate struct {
+	*document.Mandate
+
+	// Admin reports if the role of the mandate is one of the AdminRoles of the binding.
+	Admin bool
+}
+
+// VerifiedToken is the result of VerifyBindingToken.
+type VerifiedToken struct {
+	// Key is the key of the client that signed the token, or the issuer of its certificate chain.
+	Key *jose.JsonWebKey
+
+	// Mandates are the verified mandates of the token.
+	Mandates []VerifiedMandate
+}
+
+// IsAdmin reports if any of the mandates gives access to administer the binding.
+func (v *VerifiedToken) IsAdmin() bool {
+	for _, m := range v.Mandates {
+		if m.Admin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsAdminMandate reports if the mandate, signed by the signer, gives access to administer the binding.
+// The signer has to be the realm the binding is bound to and the role one of the AdminRoles of the binding.
+func IsAdminMandate(b Binding, signer *jose.JsonWebKey, mandate *document.Mandate) bool {
+	realm := b.Realm()
+	if realm == nil || realm.PublicKey == nil || signer == nil || mandate == nil {
+		return false
+	}
+
+	if crypto.Thumbprint(signer) != crypto.Thumbprint(realm.PublicKey) {
+		return false
+	}
+
+	for _, role := range b.AdminRoles() {
+		if role == mandate.Role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VerifyBindingToken verifies a mandate-token with the realm the binding is bound to as the signer of the mandates,
+// and matches the role of each mandate against the AdminRoles of the binding.
+// If opts.Audience is set, the binding ID is accepted as an audience as well.
+// Returns ErrNotBound if the binding is not bound to a realm, or a *VerifyError if the token is rejected.
+func VerifyBindingToken(token string, b Binding, keyLevel int, opts VerifyOptions) (*VerifiedToken, error) {
+	realm := b.Realm()
+	if realm == nil || realm.PublicKey == nil {
+		return nil, ErrNotBound
+	}
+
+	if len(opts.Audience) > 0 {
+		opts.Audience = append([]string{b.ID()}, opts.Audience...)
+	}
+
+	mandates, key, err := VerifyMandateTokenWithOptions(token, realm.PublicKey, keyLevel, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	verified := &VerifiedToken{
+		Key:      key,
+		Mandates: make([]VerifiedMandate, 0, len(mandates)),
+	}
+	for _, m := range mandates {
+		verified.Mandates = append(verified.Mandates, VerifiedMandate{
+			Mandate: m,
+			Admin:   IsAdminMandate(b, realm.PublicKey, m),
+		})
+	}
+
+	return verified, nil
 }
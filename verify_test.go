@@ -0,0 +1,391 @@
+package controller_test
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	controller "github.com/Brickchain/go-controller.v2"
+	"github.com/Brickchain/go-crypto.v2"
+	"github.com/Brickchain/go-document.v2"
+	"github.com/pkg/errors"
+	jose "gopkg.in/square/go-jose.v1"
+)
+
+func newMandate(t *testing.T, signer *jose.JsonWebKey, role string, validFrom, validUntil time.Time) string {
+	t.Helper()
+
+	mandate, _ := json.Marshal(&document.Mandate{
+		Base:       document.Base{Type: "mandate"},
+		Role:       role,
+		ValidFrom:  validFrom,
+		ValidUntil: validUntil,
+	})
+
+	return string(signWith(t, signer, string(mandate)))
+}
+
+func newMandateToken(t *testing.T, client *jose.JsonWebKey, timestamp time.Time, ttl int, mandates ...string) string {
+	t.Helper()
+
+	token, _ := json.Marshal(&document.MandateToken{
+		Base:     document.Base{Type: "mandate-token", Timestamp: timestamp},
+		Mandates: mandates,
+		TTL:      ttl,
+	})
+
+	return string(signWith(t, client, string(token)))
+}
+
+// malleate replaces the ECDSA P-256 signature (r, s) of the JWS with (r, n-s), which verifies as well
+func malleate(t *testing.T, token string) string {
+	t.Helper()
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(token), &fields); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(fields["signature"].(string))
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("malleate() signature %x, %v", sig, err)
+	}
+
+	s := new(big.Int).Sub(elliptic.P256().Params().N, new(big.Int).SetBytes(sig[32:]))
+	altered := make([]byte, 64)
+	copy(altered, sig[:32])
+	copy(altered[64-len(s.Bytes()):], s.Bytes())
+	fields["signature"] = base64.RawURLEncoding.EncodeToString(altered)
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func Test_VerifyMandateTokenWithOptions(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	clientKey, _ := crypto.NewKey()
+	otherKey, _ := crypto.NewKey()
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	valid := newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		token    string
+		skew     time.Duration
+		mandates int
+		reason   controller.VerifyReason
+		index    int
+	}{
+		{
+			name:     "Valid",
+			token:    newMandateToken(t, clientKey, now, 60, valid),
+			mandates: 1,
+		},
+		{
+			name:     "OpenEnded",
+			token:    newMandateToken(t, clientKey, now, 60, newMandate(t, realmKey, "admin@example.com", time.Time{}, time.Time{})),
+			mandates: 1,
+		},
+		{
+			name:   "Malformed",
+			token:  "not a token",
+			reason: controller.VerifyMalformed,
+			index:  -1,
+		},
+		{
+			name:   "TokenExpired",
+			token:  newMandateToken(t, clientKey, now.Add(-2*time.Minute), 60, valid),
+			reason: controller.VerifyTokenExpired,
+			index:  -1,
+		},
+		{
+			name:     "TokenExpiredWithinSkew",
+			token:    newMandateToken(t, clientKey, now.Add(-65*time.Second), 60, valid),
+			skew:     10 * time.Second,
+			mandates: 1,
+		},
+		{
+			name:   "MandateNotYetValid",
+			token:  newMandateToken(t, clientKey, now, 60, newMandate(t, realmKey, "admin@example.com", now.Add(5*time.Second), now.Add(time.Hour))),
+			reason: controller.VerifyMandateNotYetValid,
+		},
+		{
+			name:     "MandateNotYetValidWithinSkew",
+			token:    newMandateToken(t, clientKey, now, 60, newMandate(t, realmKey, "admin@example.com", now.Add(5*time.Second), now.Add(time.Hour))),
+			skew:     10 * time.Second,
+			mandates: 1,
+		},
+		{
+			name:   "MandateExpired",
+			token:  newMandateToken(t, clientKey, now, 60, valid, newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(-5*time.Second))),
+			reason: controller.VerifyMandateExpired,
+			index:  1,
+		},
+		{
+			name:     "MandateExpiredWithinSkew",
+			token:    newMandateToken(t, clientKey, now, 60, valid, newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(-5*time.Second))),
+			skew:     10 * time.Second,
+			mandates: 2,
+		},
+		{
+			name:   "WrongMandateSigner",
+			token:  newMandateToken(t, clientKey, now, 60, newMandate(t, otherKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))),
+			reason: controller.VerifyWrongSigner,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := controller.VerifyOptions{Clock: clock, Skew: tt.skew}
+			mandates, key, err := controller.VerifyMandateTokenWithOptions(tt.token, realmPK, 0, opts)
+			if tt.reason != 0 {
+				if !errors.Is(err, tt.reason) {
+					t.Fatalf("VerifyMandateTokenWithOptions() error = %v, want %v", err, tt.reason)
+				}
+				var verr *controller.VerifyError
+				if !errors.As(err, &verr) || verr.Mandate != tt.index {
+					t.Errorf("VerifyMandateTokenWithOptions() error = %#v, want mandate index %d", err, tt.index)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyMandateTokenWithOptions() error = %v", err)
+			}
+			if len(mandates) != tt.mandates {
+				t.Errorf("VerifyMandateTokenWithOptions() got %d mandates, want %d", len(mandates), tt.mandates)
+			}
+			if crypto.Thumbprint(key) != crypto.Thumbprint(clientKey) {
+				t.Errorf("VerifyMandateTokenWithOptions() did not return the client key")
+			}
+		})
+	}
+}
+
+func Test_VerifyBindingToken(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	clientKey, _ := crypto.NewKey()
+	otherKey, _ := crypto.NewKey()
+
+	now := time.Now()
+	admin := newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+	user := newMandate(t, realmKey, "user@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+	forged := newMandate(t, otherKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+
+	bsvc := controller.NewMemoryBindingService()
+
+	b, err := bsvc.New("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := newMandateToken(t, clientKey, now, 60, user, admin)
+	if _, err := controller.VerifyBindingToken(token, b, 0, controller.VerifyOptions{}); err != controller.ErrNotBound {
+		t.Errorf("VerifyBindingToken() on unbound binding error = %v, want %v", err, controller.ErrNotBound)
+	}
+
+	err = b.Bind(&document.ControllerBinding{
+		ControllerCertificate: "certificate",
+		RealmDescriptor:       &document.RealmDescriptor{Name: "example.com", PublicKey: realmPK},
+		AdminRoles:            []string{"admin@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = bsvc.Get("test")
+
+	got, err := controller.VerifyBindingToken(token, b, 0, controller.VerifyOptions{})
+	if err != nil {
+		t.Fatalf("VerifyBindingToken() error = %v", err)
+	}
+	if crypto.Thumbprint(got.Key) != crypto.Thumbprint(clientKey) {
+		t.Errorf("VerifyBindingToken() did not return the client key")
+	}
+	if len(got.Mandates) != 2 || got.Mandates[0].Admin || !got.Mandates[1].Admin {
+		t.Errorf("VerifyBindingToken() mandates = %+v, want user then admin", got.Mandates)
+	}
+	if !got.IsAdmin() {
+		t.Error("VerifyBindingToken() IsAdmin() = false")
+	}
+
+	got, err = controller.VerifyBindingToken(newMandateToken(t, clientKey, now, 60, user), b, 0, controller.VerifyOptions{})
+	if err != nil || got.IsAdmin() {
+		t.Errorf("VerifyBindingToken() with user mandate = %+v, %v, want no admin", got, err)
+	}
+
+	_, err = controller.VerifyBindingToken(newMandateToken(t, clientKey, now, 60, forged), b, 0, controller.VerifyOptions{})
+	if !errors.Is(err, controller.VerifyWrongSigner) {
+		t.Errorf("VerifyBindingToken() with forged mandate error = %v, want %v", err, controller.VerifyWrongSigner)
+	}
+}
+
+func Test_VerifyMandateToken_Replay(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	clientKey, _ := crypto.NewKey()
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	opts := controller.VerifyOptions{
+		Clock:       func() time.Time { return now },
+		ReplayCache: controller.NewMemoryReplayCache(0),
+	}
+	mandate := newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+
+	withID := func(id string) string {
+		token, _ := json.Marshal(&document.MandateToken{
+			Base:     document.Base{Type: "mandate-token", Timestamp: now, ID: id},
+			Mandates: []string{mandate},
+			TTL:      60,
+		})
+		return string(signWith(t, clientKey, string(token)))
+	}
+
+	altered := newMandateToken(t, clientKey, now.Add(-time.Second), 60, mandate)
+
+	tests := []struct {
+		name  string
+		first string
+		again string
+	}{
+		{"SameToken", newMandateToken(t, clientKey, now, 60, mandate), ""},
+		{"SameID", withID("token-1"), withID("token-1")},
+		{"AlteredSignature", altered, malleate(t, altered)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.again == "" {
+				tt.again = tt.first
+			}
+			if _, _, err := controller.VerifyMandateTokenWithOptions(tt.first, realmPK, 0, opts); err != nil {
+				t.Fatalf("VerifyMandateTokenWithOptions() first use error = %v", err)
+			}
+			if _, _, err := controller.VerifyMandateTokenWithOptions(tt.again, realmPK, 0, opts); !errors.Is(err, controller.VerifyReplayed) {
+				t.Errorf("VerifyMandateTokenWithOptions() replay error = %v, want %v", err, controller.VerifyReplayed)
+			}
+		})
+	}
+
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now.Add(-2*time.Second), 60, mandate), realmPK, 0, opts); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() new token error = %v", err)
+	}
+	if _, _, err := controller.VerifyMandateTokenWithOptions(withID("token-2"), realmPK, 0, opts); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() new ID error = %v", err)
+	}
+}
+
+func Test_VerifyMandateToken_MaxTTL(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	clientKey, _ := crypto.NewKey()
+
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	mandate := newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(24*time.Hour))
+	opts := controller.VerifyOptions{
+		Clock:       func() time.Time { return now },
+		ReplayCache: controller.NewMemoryReplayCache(1),
+	}
+
+	// tokens valid for longer than DefaultMaxTokenTTL never reach the cache, so they can not fill it
+	for i, token := range []string{
+		newMandateToken(t, clientKey, now, 1<<30, mandate),
+		newMandateToken(t, clientKey, now.Add(time.Hour), 60, mandate),
+	} {
+		if _, _, err := controller.VerifyMandateTokenWithOptions(token, realmPK, 0, opts); !errors.Is(err, controller.VerifyTokenTTLTooLong) {
+			t.Errorf("VerifyMandateTokenWithOptions() token %d error = %v, want %v", i, err, controller.VerifyTokenTTLTooLong)
+		}
+	}
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now, 60, mandate), realmPK, 0, opts); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() after long TTL tokens error = %v", err)
+	}
+
+	// the cache is full until the accepted token expires
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now.Add(-time.Second), 60, mandate), realmPK, 0, opts); !errors.Is(err, controller.ErrReplayCacheFull) {
+		t.Errorf("VerifyMandateTokenWithOptions() on full cache error = %v, want %v", err, controller.ErrReplayCacheFull)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now, 60, mandate), realmPK, 0, opts); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() after the cached token expired error = %v", err)
+	}
+
+	opts.ReplayCache = controller.NewMemoryReplayCache(0)
+	opts.MaxTTL = 24 * time.Hour
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now, 3600, mandate), realmPK, 0, opts); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() with MaxTTL error = %v", err)
+	}
+
+	if _, _, err := controller.VerifyMandateTokenWithOptions(newMandateToken(t, clientKey, now, 1<<30, mandate), realmPK, 0, controller.VerifyOptions{Clock: opts.Clock}); err != nil {
+		t.Errorf("VerifyMandateTokenWithOptions() without ReplayCache error = %v", err)
+	}
+}
+
+func Test_VerifyMandateToken_Audience(t *testing.T) {
+	realmKey, _ := crypto.NewKey()
+	realmPK, _ := crypto.NewPublicKey(realmKey)
+	clientKey, _ := crypto.NewKey()
+
+	now := time.Now()
+	mandate := newMandate(t, realmKey, "admin@example.com", now.Add(-time.Hour), now.Add(time.Hour))
+	withURI := func(uri string) string {
+		token, _ := json.Marshal(&document.MandateToken{
+			Base:     document.Base{Type: "mandate-token", Timestamp: now},
+			Mandates: []string{mandate},
+			URI:      uri,
+			TTL:      60,
+		})
+		return string(signWith(t, clientKey, string(token)))
+	}
+
+	tests := []struct {
+		name     string
+		uri      string
+		audience []string
+		wantErr  bool
+	}{
+		{"NoAudience", "https://other.example.com/", nil, false},
+		{"Exact", "https://controller.example.com", []string{"https://controller.example.com"}, false},
+		{"Below", "https://controller.example.com/actions?binding=test", []string{"https://controller.example.com"}, false},
+		{"BelowSlash", "https://controller.example.com/actions", []string{"https://controller.example.com/"}, false},
+		{"SecondAudience", "test", []string{"https://controller.example.com", "test"}, false},
+		{"OtherHost", "https://controller.example.com.evil.com/", []string{"https://controller.example.com"}, true},
+		{"OtherController", "https://other.example.com/", []string{"https://controller.example.com"}, true},
+		{"MissingURI", "", []string{"https://controller.example.com"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := controller.VerifyOptions{Audience: tt.audience}
+			_, _, err := controller.VerifyMandateTokenWithOptions(withURI(tt.uri), realmPK, 0, opts)
+			if tt.wantErr && !errors.Is(err, controller.VerifyWrongAudience) {
+				t.Errorf("VerifyMandateTokenWithOptions() error = %v, want %v", err, controller.VerifyWrongAudience)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("VerifyMandateTokenWithOptions() error = %v", err)
+			}
+		})
+	}
+
+	t.Run("BindingID", func(t *testing.T) {
+		b, _ := controller.NewMemoryBindingService().New("test")
+		err := b.Bind(&document.ControllerBinding{
+			RealmDescriptor: &document.RealmDescriptor{Name: "example.com", PublicKey: realmPK},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts := controller.VerifyOptions{Audience: []string{"https://controller.example.com"}}
+		if _, err := controller.VerifyBindingToken(withURI("test"), b, 0, opts); err != nil {
+			t.Errorf("VerifyBindingToken() with binding ID error = %v", err)
+		}
+		if _, err := controller.VerifyBindingToken(withURI("other"), b, 0, opts); !errors.Is(err, controller.VerifyWrongAudience) {
+			t.Errorf("VerifyBindingToken() with other binding ID error = %v, want %v", err, controller.VerifyWrongAudience)
+		}
+	})
+}